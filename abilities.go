@@ -0,0 +1,123 @@
+package main
+
+import "sort"
+
+const maxSummons = 3
+const minSplitHealth = 4
+
+func (game *Game) isEmpty(pos Point) bool {
+	return game.IsFree(pos) && game.getMonsterAt(pos) == nil && game.getPlayerAt(pos) == nil
+}
+
+func (game *Game) freeNeighbors(pos Point) []Point {
+	result := []Point{}
+	for _, dir := range dirs {
+		p := pos.Move(dir)
+		if game.isEmpty(p) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func (monster *Monster) Shoot(target *Player) bool {
+	game := monster.Game
+	if monster.Pos.X != target.Pos.X && monster.Pos.Y != target.Pos.Y {
+		return false
+	}
+	dist := monster.Pos.Dist(target.Pos)
+	if dist < 2 || dist > monster.Class.Range {
+		return false
+	}
+
+	dir := monster.Pos.Dir(target.Pos)
+	for pos := monster.Pos.Move(dir); pos != target.Pos; pos = pos.Move(dir) {
+		if !game.isEmpty(pos) {
+			return false
+		}
+	}
+
+	if game.IsVisible(monster.Pos) {
+		game.Enqueue(Message{
+			"action": "projectile",
+			"from":   monster.Pos,
+			"to":     target.Pos,
+		})
+	}
+	monster.Hit(target)
+	return true
+}
+
+func (monster *Monster) Flee(target *Player) {
+	game := monster.Game
+	best := monster.Pos
+	bestDist := monster.Pos.Dist(target.Pos)
+	for _, pos := range game.freeNeighbors(monster.Pos) {
+		if dist := pos.Dist(target.Pos); dist > bestDist {
+			best = pos
+			bestDist = dist
+		}
+	}
+
+	if best != monster.Pos {
+		monster.Dir = monster.Pos.Dir(best)
+		monster.setPos(best)
+	} else if bestDist == 1 {
+		monster.Hit(target)
+	}
+}
+
+func (monster *Monster) DoSummon() bool {
+	game := monster.Game
+	c := monster.Game.content.findMonsterClass(monster.Class.Summon)
+	if c == nil || monster.Summons >= maxSummons {
+		return false
+	}
+
+	neighbors := game.freeNeighbors(monster.Pos)
+	if len(neighbors) == 0 {
+		return false
+	}
+
+	minion := makeMonster(game, c, neighbors[game.aiRand.Intn(len(neighbors))])
+	game.Monsters[minion] = true
+	monster.Summons += 1
+	return true
+}
+
+func (monster *Monster) Split() bool {
+	game := monster.Game
+	health := monster.MaxHealth / 2
+	if !monster.Class.Splits || health < minSplitHealth {
+		return false
+	}
+
+	neighbors := append([]Point{monster.Pos}, game.freeNeighbors(monster.Pos)...)
+	for _, pos := range neighbors[:min(2, len(neighbors))] {
+		child := makeMonster(game, monster.Class, pos)
+		child.Health = health
+		child.MaxHealth = health
+		child.Attack = monster.Attack
+		child.Defense = monster.Defense
+		child.XP = monster.XP / 2
+		game.Monsters[child] = true
+	}
+	return true
+}
+
+func (monster *Monster) Steal(player *Player) {
+	names := []string{}
+	for name := range player.Inventory {
+		if name != player.Weapon && name != player.Armor && name != player.Ranged {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	name := names[monster.Game.aiRand.Intn(len(names))]
+	player.RemoveItem(name)
+	monster.Loot[name] += 1
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxNoticeLength = 500
+
+var adminToken = ""
+
+type AdminCommand struct {
+	Action string
+	Player int
+	Text   string
+	Reply  chan AdminResult
+}
+
+type AdminResult struct {
+	Data any
+	Err  error
+}
+
+type GameInfo struct {
+	Id         string `json:"id"`
+	Level      uint   `json:"level"`
+	Players    int    `json:"players"`
+	Spectators int    `json:"spectators"`
+	Monsters   int    `json:"monsters"`
+	Generator  string `json:"generator"`
+}
+
+var errNotFound = errors.New("not found")
+
+func (game *Game) Info() GameInfo {
+	return GameInfo{
+		Id:         game.Id,
+		Level:      game.Level,
+		Players:    len(game.Players),
+		Spectators: len(game.Spectators),
+		Monsters:   len(game.Monsters),
+		Generator:  game.Generator,
+	}
+}
+
+func (game *Game) Notice(text string) {
+	game.Enqueue(Message{
+		"action": "notice",
+		"text":   text,
+	})
+}
+
+func (game *Game) handleAdmin(cmd AdminCommand) AdminResult {
+	if cmd.Action == "info" {
+		return AdminResult{game.Info(), nil}
+	} else if cmd.Action == "state" {
+		snapshot := game.Snapshot()
+		for i := range snapshot.Players {
+			snapshot.Players[i].Token = ""
+		}
+		return AdminResult{snapshot, nil}
+	} else if cmd.Action == "kick" {
+		player := game.getPlayer(cmd.Player)
+		if player == nil {
+			return AdminResult{nil, errNotFound}
+		}
+		player.Enqueue(Message{
+			"action": "notice",
+			"text":   "You have been kicked.",
+		})
+		player.Flush()
+		game.removePlayer(player)
+		return AdminResult{nil, nil}
+	} else if cmd.Action == "notice" {
+		game.Notice(cmd.Text)
+		return AdminResult{nil, nil}
+	} else if cmd.Action == "end" {
+		game.Notice("This game has been ended by an administrator.")
+		return AdminResult{nil, nil}
+	}
+	return AdminResult{nil, errors.New("unknown command")}
+}
+
+func (game *Game) sendAdmin(cmd AdminCommand) AdminResult {
+	cmd.Reply = make(chan AdminResult, 1)
+	select {
+	case game.admin <- cmd:
+		return <-cmd.Reply
+	case <-game.ctx.Done():
+		return AdminResult{nil, errNotFound}
+	}
+}
+
+func listGames() []*Game {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	list := []*Game{}
+	for _, game := range games {
+		list = append(list, game)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id < list[j].Id
+	})
+	return list
+}
+
+func lookupGame(id string) *Game {
+	mux.RLock()
+	defer mux.RUnlock()
+	return games[id]
+}
+
+func isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return adminToken != "" && ok && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+func adminHandler(handler func(w http.ResponseWriter, r *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		data, err := handler(w, r)
+		if errors.Is(err, errNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else if data == nil {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(data)
+		}
+	}
+}
+
+func adminGame(action string) func(w http.ResponseWriter, r *http.Request) (any, error) {
+	return func(w http.ResponseWriter, r *http.Request) (any, error) {
+		game := lookupGame(r.PathValue("id"))
+		if game == nil {
+			return nil, errNotFound
+		}
+
+		cmd := AdminCommand{Action: action}
+		if action == "kick" {
+			id, err := strconv.Atoi(r.PathValue("player"))
+			if err != nil {
+				return nil, errNotFound
+			}
+			cmd.Player = id
+		} else if action == "notice" {
+			text, err := readNotice(r)
+			if err != nil {
+				return nil, err
+			}
+			cmd.Text = text
+		}
+
+		result := game.sendAdmin(cmd)
+		return result.Data, result.Err
+	}
+}
+
+func readNotice(r *http.Request) (string, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4*maxNoticeLength))
+	if err != nil {
+		return "", err
+	}
+	text := sanitize(string(body), maxNoticeLength)
+	if text == "" {
+		return "", errors.New("empty notice")
+	}
+	return text, nil
+}
+
+func adminListGames(w http.ResponseWriter, r *http.Request) (any, error) {
+	infos := []GameInfo{}
+	for _, game := range listGames() {
+		result := game.sendAdmin(AdminCommand{Action: "info"})
+		if result.Err == nil {
+			infos = append(infos, result.Data.(GameInfo))
+		}
+	}
+	return infos, nil
+}
+
+func adminBroadcast(w http.ResponseWriter, r *http.Request) (any, error) {
+	text, err := readNotice(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, game := range listGames() {
+		game.sendAdmin(AdminCommand{Action: "notice", Text: text})
+	}
+	return nil, nil
+}
+
+func adminReload(w http.ResponseWriter, r *http.Request) (any, error) {
+	return nil, reloadContent()
+}
+
+func registerAdmin() {
+	http.HandleFunc("GET /admin/games", adminHandler(adminListGames))
+	http.HandleFunc("GET /admin/games/{id}", adminHandler(adminGame("state")))
+	http.HandleFunc("POST /admin/games/{id}/end", adminHandler(adminGame("end")))
+	http.HandleFunc("POST /admin/games/{id}/notice", adminHandler(adminGame("notice")))
+	http.HandleFunc("POST /admin/games/{id}/players/{player}/kick", adminHandler(adminGame("kick")))
+	http.HandleFunc("POST /admin/notice", adminHandler(adminBroadcast))
+	http.HandleFunc("POST /admin/reload", adminHandler(adminReload))
+}
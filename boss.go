@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+const bossInterval = 5
+const bossDropValue = 1000
+
+func (c *Content) bossClass() *MonsterClass {
+	for i := range c.MonsterClasses {
+		if c.MonsterClasses[i].Boss {
+			return &c.MonsterClasses[i]
+		}
+	}
+	return nil
+}
+
+func (c *Content) RandomBossItem(r *rand.Rand) string {
+	names := []string{}
+	best := ""
+	for name, item := range c.Items {
+		if item.Type == AMMO || item.Type == KEY {
+			continue
+		}
+		if item.Value >= bossDropValue {
+			names = append(names, name)
+		}
+		if best == "" || item.Value > c.Items[best].Value || (item.Value == c.Items[best].Value && name < best) {
+			best = name
+		}
+	}
+	if len(names) == 0 {
+		return best
+	}
+	sort.Strings(names)
+	return names[r.Intn(len(names))]
+}
+
+func (game *Game) generateBoss(rect Rect) {
+	game.Boss = 0
+	c := game.content.bossClass()
+	if game.Level%bossInterval != 0 || c == nil {
+		return
+	}
+
+	candidates := []Point{}
+	for y := rect.Y1; y <= rect.Y2; y++ {
+		for x := rect.X1; x <= rect.X2; x++ {
+			p := Point{x, y}
+			if p != game.Ladder && game.IsFree(p) && game.getMonsterAt(p) == nil {
+				candidates = append(candidates, p)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	pos := candidates[game.rand.Intn(len(candidates))]
+	boss := makeMonster(game, c, pos)
+	game.Monsters[boss] = true
+	game.Boss = boss.Id
+}
+
+func (game *Game) LadderMessage() Message {
+	return Message{
+		"action": "setLadder",
+		"ladder": game.Ladder,
+		"locked": game.Boss != 0,
+	}
+}
+
+func (monster *Monster) DefeatBoss() {
+	game := monster.Game
+	game.Boss = 0
+	game.addToPile(monster.Pos, game.content.RandomBossItem(game.lootRand), 1)
+	game.Enqueue(game.LadderMessage())
+	game.MaybeNextLevel()
+}
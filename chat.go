@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+const maxChatLength = 200
+const chatScrollback = 20
+const chatBurst = 5
+const chatInterval = 2 * time.Second
+
+func sanitize(text string, max int) string {
+	runes := []rune{}
+	for _, r := range strings.TrimSpace(text) {
+		if unicode.IsSpace(r) {
+			r = ' '
+		}
+		if unicode.IsPrint(r) && len(runes) < max {
+			runes = append(runes, r)
+		}
+	}
+	return strings.TrimSpace(string(runes))
+}
+
+func (player *Player) Say(text string) {
+	text = sanitize(text, maxChatLength)
+	if text == "" {
+		return
+	}
+
+	game := player.Game
+	msg := Message{
+		"action": "say",
+		"id":     player.Id,
+		"name":   player.Name,
+		"color":  player.Color,
+		"text":   text,
+	}
+	game.Enqueue(msg)
+
+	game.chat = append(game.chat, msg)
+	if len(game.chat) > chatScrollback {
+		game.chat = game.chat[len(game.chat)-chatScrollback:]
+	}
+}
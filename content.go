@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type Content struct {
+	Items          map[string]Item
+	MonsterClasses []MonsterClass
+}
+
+var content = &Content{Items, MonsterClasses}
+var itemsFile = ""
+var monstersFile = ""
+
+var effectNames = map[string]bool{
+	POISON:       true,
+	REGENERATION: true,
+	HASTE:        true,
+	SLOW:         true,
+}
+
+func (c MonsterClass) MarshalJSON() ([]byte, error) {
+	type Alias MonsterClass
+	summon := ""
+	if c.Summon != 0 {
+		summon = string(c.Summon)
+	}
+	return json.Marshal(struct {
+		Alias
+		Rune   string `json:"rune"`
+		Summon string `json:"summon,omitempty"`
+	}{Alias(c), string(c.Rune), summon})
+}
+
+func (c *MonsterClass) UnmarshalJSON(data []byte) error {
+	type Alias MonsterClass
+	aux := struct {
+		*Alias
+		Rune   string `json:"rune"`
+		Summon string `json:"summon"`
+	}{Alias: (*Alias)(c)}
+	if err := decodeStrict(data, &aux); err != nil {
+		return err
+	}
+
+	r := []rune(aux.Rune)
+	if len(r) != 1 {
+		return fmt.Errorf("rune must be a single character, got %q", aux.Rune)
+	}
+	c.Rune = r[0]
+
+	c.Summon = 0
+	if aux.Summon != "" {
+		r = []rune(aux.Summon)
+		if len(r) != 1 {
+			return fmt.Errorf("summon must be a single character, got %q", aux.Summon)
+		}
+		c.Summon = r[0]
+	}
+	return nil
+}
+
+func decodeStrict(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+func validateEffect(effect *EffectSpec) error {
+	if effect == nil {
+		return nil
+	}
+	if !effectNames[effect.Name] {
+		return fmt.Errorf("unknown effect %q", effect.Name)
+	}
+	if effect.Duration <= 0 {
+		return fmt.Errorf("effect duration must be positive")
+	}
+	return nil
+}
+
+func validateItem(name string, item Item, items map[string]Item) error {
+	if name == "" || name == GOLD {
+		return fmt.Errorf("invalid item name %q", name)
+	}
+	if item.Type < CONSUMABLE || item.Type > KEY {
+		return fmt.Errorf("unknown type %d", item.Type)
+	}
+	if item.Value == 0 {
+		return fmt.Errorf("value must be positive")
+	}
+	if item.Health != 0 && item.Type != CONSUMABLE {
+		return fmt.Errorf("only consumables can restore health")
+	}
+	if err := validateEffect(item.Effect); err != nil {
+		return err
+	}
+	if item.Type == RANGED && item.Range <= 0 {
+		return fmt.Errorf("range must be positive for ranged weapons")
+	}
+	if item.Ammo != "" && items[item.Ammo].Type != AMMO && item.Ammo != name {
+		return fmt.Errorf("ammo %q is not an ammo item", item.Ammo)
+	}
+	return nil
+}
+
+func validateItems(items map[string]Item) error {
+	if len(items) == 0 {
+		return fmt.Errorf("no items defined")
+	}
+
+	names := []string{}
+	for name := range items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	loot := false
+	for _, name := range names {
+		if err := validateItem(name, items[name], items); err != nil {
+			return fmt.Errorf("item %q: %w", name, err)
+		}
+		loot = loot || items[name].Type != KEY
+	}
+	if !loot {
+		return fmt.Errorf("no items besides keys defined")
+	}
+	return nil
+}
+
+func validateMonsterClass(c MonsterClass, classes []MonsterClass, items map[string]Item) error {
+	if c.HealthBase <= 0 {
+		return fmt.Errorf("healthBase must be positive")
+	}
+	if c.Probability < 0 {
+		return fmt.Errorf("probability must not be negative")
+	}
+	if c.SummonChance < 0 || c.SummonChance > 1 {
+		return fmt.Errorf("summonChance must be between 0 and 1")
+	}
+	if c.FleeBelow < 0 || c.FleeBelow > 1 {
+		return fmt.Errorf("fleeBelow must be between 0 and 1")
+	}
+	if c.Range < 0 {
+		return fmt.Errorf("range must not be negative")
+	}
+	if err := validateEffect(c.Effect); err != nil {
+		return err
+	}
+	if c.Drops != "" {
+		if _, ok := items[c.Drops]; !ok {
+			return fmt.Errorf("drops unknown item %q", c.Drops)
+		}
+	}
+	if c.Summon != 0 {
+		found := false
+		for _, other := range classes {
+			found = found || other.Rune == c.Summon
+		}
+		if !found {
+			return fmt.Errorf("summons unknown monster %q", string(c.Summon))
+		}
+	}
+	return nil
+}
+
+func validateMonsterClasses(classes []MonsterClass, items map[string]Item) error {
+	seen := make(map[rune]bool)
+	first := false
+	for i, c := range classes {
+		if seen[c.Rune] {
+			return fmt.Errorf("monster %d (%q): duplicate rune", i, string(c.Rune))
+		}
+		seen[c.Rune] = true
+		if err := validateMonsterClass(c, classes, items); err != nil {
+			return fmt.Errorf("monster %d (%q): %w", i, string(c.Rune), err)
+		}
+		first = first || (c.Probability > 0 && c.MinLevel <= 1 && !c.Boss)
+	}
+	if !first {
+		return fmt.Errorf("no monster can appear on the first level")
+	}
+	return nil
+}
+
+func loadItems(path string) (map[string]Item, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]Item)
+	if err := decodeStrict(data, &items); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validateItems(items); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return items, nil
+}
+
+func loadMonsterClasses(path string, items map[string]Item) ([]MonsterClass, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	classes := []MonsterClass{}
+	if err := decodeStrict(data, &classes); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validateMonsterClasses(classes, items); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return classes, nil
+}
+
+func loadContent() (*Content, error) {
+	items := Items
+	if itemsFile != "" {
+		var err error
+		items, err = loadItems(itemsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	classes := MonsterClasses
+	if monstersFile != "" {
+		var err error
+		classes, err = loadMonsterClasses(monstersFile, items)
+		if err != nil {
+			return nil, err
+		}
+	} else if err := validateMonsterClasses(classes, items); err != nil {
+		return nil, fmt.Errorf("built-in monsters: %w", err)
+	}
+
+	return &Content{items, classes}, nil
+}
+
+func currentContent() *Content {
+	mux.RLock()
+	defer mux.RUnlock()
+	return content
+}
+
+func reloadContent() error {
+	c, err := loadContent()
+	if err != nil {
+		return err
+	}
+
+	mux.Lock()
+	content = c
+	list := []*Game{}
+	for _, game := range games {
+		list = append(list, game)
+	}
+	mux.Unlock()
+
+	for _, game := range list {
+		select {
+		case game.reload <- c:
+		case <-game.ctx.Done():
+		}
+	}
+	return nil
+}
+
+func (game *Game) applyContent(c *Content) {
+	old := game.content
+	game.content = c
+
+	for monster := range game.Monsters {
+		if class := c.findMonsterClass(monster.Rune); class != nil {
+			monster.Class = class
+		}
+		for name := range monster.Loot {
+			if _, ok := c.Items[name]; !ok {
+				delete(monster.Loot, name)
+			}
+		}
+	}
+
+	for pos, pile := range game.Piles {
+		changed := false
+		for name := range pile.Items {
+			if _, ok := c.Items[name]; !ok && name != GOLD {
+				delete(pile.Items, name)
+				changed = true
+			}
+		}
+		if changed {
+			if len(pile.Items) == 0 {
+				delete(game.Piles, pos)
+				game.hide(pile.Id)
+			}
+			game.EnqueuePileContents(pos)
+		}
+	}
+
+	if game.Shop != nil {
+		shop := &Shop{Pos: game.Shop.Pos, Items: []string{}}
+		for _, name := range game.Shop.Items {
+			if _, ok := c.Items[name]; ok {
+				shop.Items = append(shop.Items, name)
+			}
+		}
+		game.Shop = shop
+	}
+
+	game.Enqueue(game.ItemsMessage())
+
+	for player := range game.Players {
+		player.ReloadItems(old, c)
+	}
+}
+
+func (game *Game) ItemsMessage() Message {
+	return Message{
+		"action": "setItems",
+		"items":  game.content.Items,
+		"shop":   game.Shop,
+	}
+}
+
+func (player *Player) reloadSlot(old *Content, c *Content, slot *string, itemType uint, action string) {
+	if *slot == "" {
+		return
+	}
+
+	player.UnapplyItem(old.Items[*slot])
+	if item, ok := c.Items[*slot]; ok && item.Type == itemType {
+		player.ApplyItem(item)
+	} else {
+		*slot = ""
+		player.Enqueue(Message{
+			"action": action,
+			"item":   "",
+		})
+	}
+}
+
+func (player *Player) ReloadItems(old *Content, c *Content) {
+	player.reloadSlot(old, c, &player.Weapon, WEAPON, "setWeapon")
+	player.reloadSlot(old, c, &player.Armor, ARMOR, "setArmor")
+	player.reloadSlot(old, c, &player.Ranged, RANGED, "setRanged")
+
+	for name := range player.Inventory {
+		if _, ok := c.Items[name]; !ok {
+			delete(player.Inventory, name)
+			player.Enqueue(Message{
+				"action": "setInventory",
+				"item":   name,
+				"amount": 0,
+			})
+		}
+	}
+
+	player.Health = max(1, min(player.Health, player.HealthTotal))
+	player.CommitStats()
+}
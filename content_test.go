@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinContentIsValid(t *testing.T) {
+	if err := validateItems(Items); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateMonsterClasses(MonsterClasses, Items); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateItem(t *testing.T) {
+	items := map[string]Item{
+		"Arrow": {Type: AMMO, Value: 10},
+		"Sword": {Type: WEAPON, Value: 10},
+	}
+	tests := []struct {
+		name string
+		item Item
+		err  string
+	}{
+		{"", Item{Type: CONSUMABLE, Value: 1}, "invalid item name"},
+		{GOLD, Item{Type: CONSUMABLE, Value: 1}, "invalid item name"},
+		{"Thing", Item{Type: 99, Value: 1}, "unknown type"},
+		{"Thing", Item{Type: CONSUMABLE}, "value must be positive"},
+		{"Thing", Item{Type: ARMOR, Value: 1, Health: 10}, "only consumables"},
+		{"Thing", Item{Type: CONSUMABLE, Value: 1, Effect: &EffectSpec{Name: "fire", Duration: 1}}, "unknown effect"},
+		{"Thing", Item{Type: CONSUMABLE, Value: 1, Effect: &EffectSpec{Name: POISON}}, "duration"},
+		{"Thing", Item{Type: RANGED, Value: 1}, "range must be positive"},
+		{"Thing", Item{Type: RANGED, Value: 1, Range: 5, Ammo: "Sword"}, "not an ammo item"},
+	}
+	for _, test := range tests {
+		err := validateItem(test.name, test.item, items)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q %+v: expected error %q, got %v", test.name, test.item, test.err, err)
+		}
+	}
+
+	if err := validateItem("Bow", Item{Type: RANGED, Value: 1, Range: 5, Ammo: "Arrow"}, items); err != nil {
+		t.Errorf("valid item rejected: %v", err)
+	}
+}
+
+func TestValidateItems(t *testing.T) {
+	if err := validateItems(map[string]Item{}); err == nil {
+		t.Errorf("empty items accepted")
+	}
+	if err := validateItems(map[string]Item{DOOR_KEY: {Type: KEY, Value: 1}}); err == nil {
+		t.Errorf("items with only keys accepted")
+	}
+}
+
+func TestValidateMonsterClasses(t *testing.T) {
+	base := MonsterClass{Rune: 'r', HealthBase: 1, Probability: 1}
+	tests := []struct {
+		classes []MonsterClass
+		err     string
+	}{
+		{[]MonsterClass{base, base}, "duplicate rune"},
+		{[]MonsterClass{{Rune: 'r', Probability: 1}}, "healthBase"},
+		{[]MonsterClass{{Rune: 'r', HealthBase: 1, Probability: -1}}, "probability"},
+		{[]MonsterClass{{Rune: 'r', HealthBase: 1, Probability: 1, SummonChance: 2}}, "summonChance"},
+		{[]MonsterClass{{Rune: 'r', HealthBase: 1, Probability: 1, FleeBelow: 2}}, "fleeBelow"},
+		{[]MonsterClass{{Rune: 'r', HealthBase: 1, Probability: 1, Range: -1}}, "range"},
+		{[]MonsterClass{{Rune: 'r', HealthBase: 1, Probability: 1, Drops: "Nothing"}}, "drops unknown item"},
+		{[]MonsterClass{{Rune: 'r', HealthBase: 1, Probability: 1, Summon: 'x'}}, "summons unknown monster"},
+		{[]MonsterClass{{Rune: 'r', HealthBase: 1, Probability: 1, MinLevel: 3}}, "first level"},
+	}
+	for _, test := range tests {
+		err := validateMonsterClasses(test.classes, Items)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%+v: expected error %q, got %v", test.classes, test.err, err)
+		}
+	}
+}
+
+func writeFile(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "content.json")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadContentErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`{"Thing": {"type": 1, "value": 1, "colour": 3}}`, "unknown field"},
+		{`{"Thing": {"type": 1, "value": 1}`, "unexpected EOF"},
+		{`{"Thing": {"type": 1}}`, "value must be positive"},
+	}
+	for _, test := range tests {
+		_, err := loadItems(writeFile(t, test.data))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.data, test.err, err)
+		}
+	}
+
+	monsters := []struct {
+		data string
+		err  string
+	}{
+		{`[{"rune": "rr", "healthBase": 1, "probability": 1}]`, "single character"},
+		{`[{"rune": "r", "healthBase": 1, "probability": 1, "summon": "xy"}]`, "single character"},
+		{`[{"rune": "r", "healthBase": 1, "probability": 1, "flying": true}]`, "unknown field"},
+	}
+	for _, test := range monsters {
+		_, err := loadMonsterClasses(writeFile(t, test.data), Items)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.data, test.err, err)
+		}
+	}
+}
+
+func TestContentRoundTrip(t *testing.T) {
+	data, err := json.Marshal(MonsterClasses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classes, err := loadMonsterClasses(writeFile(t, string(data)), Items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(classes, MonsterClasses) {
+		t.Errorf("monsters changed after a round trip")
+	}
+
+	data, err = json.Marshal(Items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := loadItems(writeFile(t, string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(items, Items) {
+		t.Errorf("items changed after a round trip")
+	}
+}
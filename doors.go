@@ -0,0 +1,166 @@
+package main
+
+import "sort"
+
+const doorChance = 0.5
+const lockChance = 0.3
+const treasureSize = 2
+
+func (grid *Grid) isChokepoint(p Point) bool {
+	up := grid.Get(Point{p.X, p.Y - 1}).Walkable()
+	down := grid.Get(Point{p.X, p.Y + 1}).Walkable()
+	left := grid.Get(Point{p.X - 1, p.Y}).Walkable()
+	right := grid.Get(Point{p.X + 1, p.Y}).Walkable()
+	return (up && down && !left && !right) || (left && right && !up && !down)
+}
+
+func (rect *Rect) Ring() []Point {
+	points := []Point{}
+	for x := rect.X1 - 1; x <= rect.X2+1; x++ {
+		points = append(points, Point{x, rect.Y1 - 1}, Point{x, rect.Y2 + 1})
+	}
+	for y := rect.Y1; y <= rect.Y2; y++ {
+		points = append(points, Point{rect.X1 - 1, y}, Point{rect.X2 + 1, y})
+	}
+	return points
+}
+
+func (game *Game) isDoorway(p Point) bool {
+	if p == (Point{0, 0}) || p == game.Ladder || game.getMonsterAt(p) != nil {
+		return false
+	}
+	if game.Shop != nil && game.Shop.Pos == p {
+		return false
+	}
+	return game.IsFree(p) && game.Grid.isChokepoint(p)
+}
+
+func (game *Game) entrances(room Rect) ([]Point, bool) {
+	entrances := []Point{}
+	for _, p := range room.Ring() {
+		if game.isDoorway(p) {
+			entrances = append(entrances, p)
+		} else if game.IsFree(p) {
+			return nil, false
+		}
+	}
+	return entrances, len(entrances) > 0
+}
+
+func (game *Game) generateDoors(rooms []Rect) {
+	// decide all positions first so doors do not change chokepoints
+	doors := []Point{}
+	for _, room := range rooms {
+		for _, p := range room.Ring() {
+			if game.isDoorway(p) && game.rand.Float64() < doorChance {
+				doors = append(doors, p)
+			}
+		}
+	}
+
+	// only dead ends are locked so the ladder never needs a key
+	var locked []Point
+	var treasury Rect
+	if len(rooms) > 2 && game.rand.Float64() < lockChance {
+		candidates := []Rect{}
+		for _, room := range rooms[1 : len(rooms)-1] {
+			if room.Contains(game.Ladder) || room.Contains(Point{0, 0}) {
+				continue
+			}
+			if game.Shop != nil && room.Contains(game.Shop.Pos) {
+				continue
+			}
+			if entrances, ok := game.entrances(room); ok && len(entrances) == 1 {
+				candidates = append(candidates, room)
+			}
+		}
+		if len(candidates) > 0 {
+			treasury = candidates[game.rand.Intn(len(candidates))]
+			locked, _ = game.entrances(treasury)
+		}
+	}
+
+	for _, p := range doors {
+		game.Grid.Set(p, DOOR)
+	}
+	for _, p := range locked {
+		game.Grid.Set(p, LOCKED)
+	}
+
+	if len(locked) > 0 && !game.placeKeeper() {
+		for _, p := range locked {
+			game.Grid.Set(p, DOOR)
+		}
+	} else if len(locked) > 0 {
+		for i := 0; i < treasureSize; i++ {
+			game.addToPile(treasury.RandomPoint(game.rand), game.content.RandomItem(game.rand), 1)
+		}
+	}
+}
+
+func (c *Content) keeperClass() *MonsterClass {
+	for i := range c.MonsterClasses {
+		if c.MonsterClasses[i].Drops == DOOR_KEY && !c.MonsterClasses[i].Boss {
+			return &c.MonsterClasses[i]
+		}
+	}
+	return nil
+}
+
+func (game *Game) placeKeeper() bool {
+	c := game.content.keeperClass()
+	if c == nil {
+		return false
+	}
+
+	// find monsters that can be reached without passing a locked door
+	seen := map[Point]bool{{0, 0}: true}
+	queue := []Point{{0, 0}}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, dir := range dirs {
+			q := p.Move(dir)
+			if !seen[q] && (game.IsFree(q) || game.Grid.Get(q) == DOOR) {
+				seen[q] = true
+				queue = append(queue, q)
+			}
+		}
+	}
+
+	candidates := []*Monster{}
+	for monster := range game.Monsters {
+		if seen[monster.Pos] && monster.Id != game.Boss {
+			candidates = append(candidates, monster)
+		}
+	}
+	if len(candidates) == 0 {
+		return false
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Id < candidates[j].Id
+	})
+
+	monster := candidates[game.rand.Intn(len(candidates))]
+	delete(game.Monsters, monster)
+	keeper := makeMonster(game, c, monster.Pos)
+	game.Monsters[keeper] = true
+	return true
+}
+
+func (player *Player) OpenDoor(pos Point) {
+	game := player.Game
+	if game.Grid.Get(pos) == LOCKED {
+		if _, ok := player.Inventory[DOOR_KEY]; !ok {
+			return
+		}
+		player.RemoveItem(DOOR_KEY)
+	}
+
+	game.Grid.Set(pos, OPEN)
+	game.Enqueue(Message{
+		"action": "setTile",
+		"pos":    pos,
+		"tile":   OPEN.String(),
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import "time"
+
+const (
+	POISON       = "poison"
+	REGENERATION = "regeneration"
+	HASTE        = "haste"
+	SLOW         = "slow"
+)
+
+const effectInterval = time.Second
+
+type EffectSpec struct {
+	Name     string `json:"name"`
+	Strength int    `json:"strength"`
+	Duration int    `json:"duration"`
+}
+
+type Effect struct {
+	Strength  int `json:"strength"`
+	Remaining int `json:"remaining"`
+}
+
+type Effects map[string]*Effect
+
+func speedDelta(name string, strength int) int {
+	switch name {
+	case HASTE:
+		return strength
+	case SLOW:
+		return -strength
+	default:
+		return 0
+	}
+}
+
+// Add applies an effect and returns the resulting change in speed.
+// Poison stacks, all other effects only keep the stronger one.
+// Applying an effect that is already active refreshes its duration.
+func (effects Effects) Add(spec EffectSpec) int {
+	effect, ok := effects[spec.Name]
+	if !ok {
+		effects[spec.Name] = &Effect{
+			Strength:  spec.Strength,
+			Remaining: spec.Duration,
+		}
+		return speedDelta(spec.Name, spec.Strength)
+	}
+
+	old := effect.Strength
+	if spec.Name == POISON {
+		effect.Strength += spec.Strength
+	} else {
+		effect.Strength = max(effect.Strength, spec.Strength)
+	}
+	effect.Remaining = max(effect.Remaining, spec.Duration)
+	return speedDelta(spec.Name, effect.Strength) - speedDelta(spec.Name, old)
+}
+
+func (effects Effects) Clone() Effects {
+	result := make(Effects)
+	for name, effect := range effects {
+		e := *effect
+		result[name] = &e
+	}
+	return result
+}
+
+func (effects Effects) Copy() map[string]Effect {
+	result := make(map[string]Effect)
+	for name, effect := range effects {
+		result[name] = *effect
+	}
+	return result
+}
+
+// Tick advances all effects by one interval and returns the resulting
+// change in health and speed.
+func (effects Effects) Tick() (int, int) {
+	health := 0
+	speed := 0
+	for name, effect := range effects {
+		switch name {
+		case POISON:
+			health -= effect.Strength
+		case REGENERATION:
+			health += effect.Strength
+		}
+
+		effect.Remaining -= 1
+		if effect.Remaining <= 0 {
+			speed -= speedDelta(name, effect.Strength)
+			delete(effects, name)
+		}
+	}
+	return health, speed
+}
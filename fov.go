@@ -0,0 +1,99 @@
+package main
+
+import "math"
+
+var rayOffsets = [][2]float64{
+	{0.4, 0.4},
+	{0.4, -0.4},
+	{-0.4, 0.4},
+	{-0.4, -0.4},
+}
+
+func round(x float64) int {
+	return int(math.Floor(x + 0.5))
+}
+
+func (game *Game) InView(a Point, b Point, r uint) bool {
+	// check radius
+	dx := a.X - b.X
+	dy := a.Y - b.Y
+	if dx*dx+dy*dy >= int(r*r) {
+		return false
+	}
+
+	// ray casting
+	if dist(a.X, b.X) > dist(a.Y, b.Y) {
+		c, d := a, b
+		if a.X > b.X {
+			c, d = b, a
+		}
+		for _, o := range rayOffsets {
+			y1 := float64(c.Y) + o[0]
+			y2 := float64(d.Y) + o[1]
+			f := (y2 - y1) / float64(d.X-c.X)
+			free := true
+			for x := c.X + 1; x < d.X && free; x++ {
+				free = game.IsTransparent(Point{x, round(float64(x-c.X)*f + y1)})
+			}
+			if free {
+				return true
+			}
+		}
+	} else {
+		c, d := a, b
+		if a.Y > b.Y {
+			c, d = b, a
+		}
+		for _, o := range rayOffsets {
+			x1 := float64(c.X) + o[0]
+			x2 := float64(d.X) + o[1]
+			f := (x2 - x1) / float64(d.Y-c.Y)
+			free := true
+			for y := c.Y + 1; y < d.Y && free; y++ {
+				free = game.IsTransparent(Point{round(float64(y-c.Y)*f + x1), y})
+			}
+			if free {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (game *Game) IsVisible(pos Point) bool {
+	for player := range game.Players {
+		if game.InView(player.Pos, pos, player.LineOfSight) {
+			return true
+		}
+	}
+	return false
+}
+
+func (game *Game) hide(id int) {
+	if game.visible[id] {
+		delete(game.visible, id)
+		game.Enqueue(Message{
+			"action": "remove",
+			"id":     id,
+		})
+	}
+}
+
+func (game *Game) updateVisibility() {
+	for monster := range game.Monsters {
+		if !game.IsVisible(monster.Pos) {
+			game.hide(monster.Id)
+		} else if !game.visible[monster.Id] {
+			game.visible[monster.Id] = true
+			game.Enqueue(monster.CreateMessage())
+		}
+	}
+	for pos, pile := range game.Piles {
+		if !game.IsVisible(pos) {
+			game.hide(pile.Id)
+		} else if !game.visible[pile.Id] {
+			game.visible[pile.Id] = true
+			game.Enqueue(pile.CreateMessage(pos))
+		}
+	}
+}
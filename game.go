@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"maps"
+	"math"
+	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Message map[string]interface{}
@@ -12,52 +20,100 @@ type Pile struct {
 	Items map[string]uint
 }
 
+func (pile *Pile) CreateMessage(pos Point) Message {
+	return Message{
+		"action": "create",
+		"type":   "pile",
+		"rune":   "%",
+		"id":     pile.Id,
+		"pos":    pos,
+	}
+}
+
 type Game struct {
-	Id         string
-	Players    map[*Player]bool
-	Monsters   map[*Monster]bool
-	Piles      map[Point]*Pile
-	Msg        chan PlayerMessage
-	MMsg       chan *Monster
-	register   chan *Player
-	unregister chan *Player
-	lastId     int
-	Rects      []Rect
-	Ladder     Point
-	Level      uint
+	Id             string
+	ctx            context.Context
+	cancel         context.CancelFunc
+	Players        map[*Player]bool
+	Spectators     map[*Player]bool
+	Monsters       map[*Monster]bool
+	Piles          map[Point]*Pile
+	visible        map[int]bool
+	chat           []Message
+	Msg            chan PlayerMessage
+	register       chan *Player
+	unregister     chan *Player
+	expire         chan *Player
+	save           chan chan GameSnapshot
+	reload         chan *Content
+	admin          chan AdminCommand
+	connectedCount atomic.Int64
+	monsterCount   atomic.Int64
+	content        *Content
+	lastId         int
+	effectsAt      time.Time
+	pathBudget     int
+	rand           *rand.Rand
+	lootRand       *rand.Rand
+	aiRand         *rand.Rand
+	Seed           int64
+	Generator      string
+	Grid           *Grid
+	Ladder         Point
+	Boss           int
+	Shop           *Shop
+	Level          uint
 }
 
+var sessionTimeout = 2 * time.Minute
+
+const tickInterval = 20 * time.Millisecond
+
 var verbose = false
 var static = false
 
 var mux = &sync.RWMutex{}
 var games = make(map[string]*Game)
 
-func getGame(id string) *Game {
-	mux.RLock()
-	game, ok := games[id]
-	mux.RUnlock()
+func makeGame(id string, seed int64, generator string) *Game {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Game{
+		Id:         id,
+		ctx:        ctx,
+		cancel:     cancel,
+		Players:    make(map[*Player]bool),
+		Spectators: make(map[*Player]bool),
+		Monsters:   make(map[*Monster]bool),
+		Piles:      make(map[Point]*Pile),
+		visible:    make(map[int]bool),
+		chat:       []Message{},
+		Msg:        make(chan PlayerMessage),
+		register:   make(chan *Player),
+		unregister: make(chan *Player),
+		expire:     make(chan *Player),
+		save:       make(chan chan GameSnapshot),
+		reload:     make(chan *Content),
+		admin:      make(chan AdminCommand),
+		content:    content,
+		lastId:     0,
+		Seed:       seed,
+		Generator:  validateGenerator(generator),
+		Level:      1,
+	}
+}
+
+func getGame(id string, seed int64, generator string) *Game {
+	mux.Lock()
+	defer mux.Unlock()
 
+	game, ok := games[id]
 	if !ok {
 		if verbose {
 			log.Println("create game", id)
 		}
-		game = &Game{
-			Id:         id,
-			Players:    make(map[*Player]bool),
-			Monsters:   make(map[*Monster]bool),
-			Piles:      make(map[Point]*Pile),
-			Msg:        make(chan PlayerMessage),
-			MMsg:       make(chan *Monster),
-			register:   make(chan *Player),
-			unregister: make(chan *Player),
-			lastId:     0,
-			Level:      1,
-		}
+		game = makeGame(id, seed, generator)
 		game.generateMap()
-		mux.Lock()
 		games[id] = game
-		mux.Unlock()
 
 		go game.run()
 	}
@@ -69,12 +125,41 @@ func (game *Game) Enqueue(msg Message) {
 	for player, _ := range game.Players {
 		player.Enqueue(msg)
 	}
+	for player, _ := range game.Spectators {
+		player.Enqueue(msg)
+	}
 }
 
 func (game *Game) Flush() {
 	for player, _ := range game.Players {
 		player.Flush()
 	}
+	for player, _ := range game.Spectators {
+		player.Flush()
+	}
+}
+
+func (game *Game) EnqueueRoster() {
+	players := []Message{}
+	for player := range game.Players {
+		players = append(players, Message{
+			"id":          player.Id,
+			"name":        player.Name,
+			"color":       player.Color,
+			"health":      player.Health,
+			"healthTotal": player.HealthTotal,
+			"level":       player.Level,
+			"connected":   player.send != nil,
+		})
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i]["id"].(int) < players[j]["id"].(int)
+	})
+
+	game.Enqueue(Message{
+		"action":  "setRoster",
+		"players": players,
+	})
 }
 
 func (game *Game) createId() int {
@@ -90,8 +175,18 @@ func (game *Game) removePlayer(player *Player) {
 	if verbose {
 		log.Println("remove player", game.Id, player.Id)
 	}
+	if player.send != nil {
+		close(player.send)
+		player.send = nil
+	}
+	game.leave(player)
+}
+
+func (game *Game) leave(player *Player) {
 	delete(game.Players, player)
-	close(player.send)
+	if player.expire != nil {
+		player.expire.Stop()
+	}
 
 	game.Enqueue(Message{
 		"action": "remove",
@@ -100,11 +195,165 @@ func (game *Game) removePlayer(player *Player) {
 	for item, amount := range player.Inventory {
 		game.addToPile(player.Pos, item, amount)
 	}
+	if player.Gold > 0 {
+		game.addToPile(player.Pos, GOLD, player.Gold)
+	}
+	game.EnqueueRoster()
+}
+
+func (game *Game) getSession(token string) *Player {
+	if token == "" {
+		return nil
+	}
+	for player := range game.Players {
+		if player.Token == token {
+			return player
+		}
+	}
+	return nil
+}
+
+func (game *Game) addPlayer(player *Player) {
+	send := player.send
+	if player.spectate {
+		game.addSpectator(player)
+	} else {
+		game.joinPlayer(player)
+	}
+
+	go player.writePump(send)
+	go player.readPump()
+}
+
+func (game *Game) EnqueueState(player *Player) {
+	player.Enqueue(game.ItemsMessage())
+	player.Enqueue(game.LevelMessage(player.tiles))
+	for monster := range game.Monsters {
+		if game.visible[monster.Id] {
+			player.Enqueue(monster.CreateMessage())
+		}
+	}
+	for pos, pile := range game.Piles {
+		if game.visible[pile.Id] {
+			player.Enqueue(pile.CreateMessage(pos))
+		}
+	}
+	for p := range game.Players {
+		player.Enqueue(p.CreateMessage())
+	}
+	for _, msg := range game.chat {
+		player.Enqueue(msg)
+	}
+}
+
+func (game *Game) joinPlayer(player *Player) {
+	old := game.getSession(player.Token)
+	if old != nil {
+		if verbose {
+			log.Println("resume player", game.Id, old.Id)
+		}
+		delete(game.Players, old)
+		if old.send != nil {
+			close(old.send)
+		}
+		if old.expire != nil {
+			old.expire.Stop()
+		}
+		player.resume(old)
+	} else {
+		player.Id = game.createId()
+		player.Token = createToken()
+		if player.Name == "" {
+			player.Name = fmt.Sprintf("Player %d", player.Id)
+		}
+		if verbose {
+			log.Println("create player", game.Id, player.Id)
+		}
+	}
+
+	player.Enqueue(Message{
+		"action": "setId",
+		"id":     player.Id,
+		"token":  player.Token,
+	})
+	player.Enqueue(player.StatsMessage())
+	game.EnqueueState(player)
+	player.Enqueue(game.PileContentsMessage(player.Pos))
+
+	game.Players[player] = true
+
+	if old != nil {
+		player.Enqueue(player.CreateMessage())
+		for item, amount := range player.Inventory {
+			player.Enqueue(Message{
+				"action": "setInventory",
+				"item":   item,
+				"amount": amount,
+			})
+		}
+		player.Enqueue(Message{
+			"action": "setWeapon",
+			"item":   player.Weapon,
+		})
+		player.Enqueue(Message{
+			"action": "setArmor",
+			"item":   player.Armor,
+		})
+		player.Enqueue(Message{
+			"action": "setRanged",
+			"item":   player.Ranged,
+		})
+		player.Enqueue(Message{
+			"action": "setGold",
+			"amount": player.Gold,
+		})
+	} else {
+		game.Enqueue(player.CreateMessage())
+	}
+	game.EnqueueRoster()
+}
+
+func (game *Game) disconnectPlayer(player *Player) {
+	if game.Spectators[player] {
+		game.removeSpectator(player)
+		return
+	}
+	if _, ok := game.Players[player]; !ok {
+		return
+	}
+
+	if verbose {
+		log.Println("disconnect player", game.Id, player.Id)
+	}
+	close(player.send)
+	player.send = nil
+	player.queue = []Message{}
+	game.expireLater(player)
+	game.EnqueueRoster()
+}
+
+func (game *Game) expireLater(player *Player) {
+	player.expire = time.AfterFunc(sessionTimeout, func() {
+		select {
+		case game.expire <- player:
+		case <-game.ctx.Done():
+		}
+	})
+}
+
+// Level generation, loot and monster behaviour use separate streams so that
+// timing dependent AI rolls do not change the loot of a seeded game.
+func (game *Game) seedLevel() {
+	seed := uint64(game.Seed) ^ uint64(game.Level)*0x9e3779b97f4a7c15
+	game.rand = rand.New(rand.NewSource(int64(seed)))
+	game.lootRand = rand.New(rand.NewSource(int64(seed ^ 0x5851f42d4c957f2d)))
+	game.aiRand = rand.New(rand.NewSource(int64(seed ^ 0x14057b7ef767814f)))
 }
 
 func (game *Game) generateMap() {
+	game.seedLevel()
+
 	for monster := range game.Monsters {
-		monster.quit <- true
 		delete(game.Monsters, monster)
 	}
 
@@ -112,71 +361,89 @@ func (game *Game) generateMap() {
 		delete(game.Piles, pos)
 	}
 
-	prev := Rect{-3, -3, 3, 3}
+	for id := range game.visible {
+		delete(game.visible, id)
+	}
 
-	game.Rects = []Rect{prev}
-	lines := []Rect{}
+	layout := game.generator().Generate(game.rand)
+	game.Grid = layout.Grid
+	game.Ladder = layout.Ladder
 
-	for i := 1; i <= 15; i++ {
-		rect := randomRect(25)
-		if rect.Area() < 150 && rect.Perimeter() < 80 {
-			game.Rects = append(game.Rects, rect)
+	for _, pos := range layout.Spawns {
+		c := game.content.randomMonsterClass(game.rand, game.Level)
+		monster := makeMonster(game, c, pos)
+		game.Monsters[monster] = true
+	}
 
-			p1 := prev.Center()
-			p2 := rect.Center()
+	game.generateBoss(layout.Rooms[len(layout.Rooms)-1])
+	game.generateShop(layout.Rooms)
+	if layout.Doors {
+		game.generateDoors(layout.Rooms)
+	}
+}
 
-			lines = append(lines, makeRect(p1.X, p1.Y, p2.X, p1.Y))
-			lines = append(lines, makeRect(p2.X, p1.Y, p2.X, p2.Y))
+func (game *Game) IsFree(p Point) bool {
+	return game.Grid.Get(p).Walkable()
+}
 
-			monster := makeMonster(game, rect.RandomPoint())
-			game.Monsters[monster] = true
+func (game *Game) IsTransparent(p Point) bool {
+	return game.Grid.Get(p).Transparent()
+}
 
-			prev = rect
-		}
+func (game *Game) LevelMessage(tiles bool) Message {
+	msg := Message{
+		"action":    "setLevel",
+		"level":     game.Level,
+		"ladder":    game.Ladder,
+		"locked":    game.Boss != 0,
+		"shop":      game.Shop,
+		"seed":      game.Seed,
+		"generator": game.Generator,
 	}
-
-	game.Ladder = prev.RandomPoint()
-
-	for _, line := range lines {
-		game.Rects = append(game.Rects, line)
+	if tiles {
+		msg["grid"] = game.Grid.Data()
+	} else {
+		msg["rects"] = game.Grid.Rects()
 	}
+	return msg
 }
 
-func (game *Game) IsFree(p Point) bool {
-	for _, rect := range game.Rects {
-		if rect.Contains(p) {
-			return true
-		}
+func (game *Game) EnqueueLevel() {
+	msgs := map[bool]Message{
+		true:  game.LevelMessage(true),
+		false: game.LevelMessage(false),
+	}
+	for player := range game.Players {
+		player.Enqueue(msgs[player.tiles])
+	}
+	for player := range game.Spectators {
+		player.Enqueue(msgs[player.tiles])
 	}
-	return false
 }
 
 func (game *Game) MaybeNextLevel() {
+	if game.Boss != 0 {
+		return
+	}
+	connected := false
 	for player := range game.Players {
+		if player.send == nil {
+			continue
+		}
 		if player.Pos != game.Ladder {
 			return
 		}
+		connected = true
+	}
+	if !connected {
+		return
 	}
 
 	game.Level += 1
+	metrics.levelUps.Add(1)
 
 	game.generateMap()
-	game.Enqueue(Message{
-		"action": "setLevel",
-		"level":  game.Level,
-		"rects":  game.Rects,
-		"ladder": game.Ladder,
-	})
-
-	for monster := range game.Monsters {
-		game.Enqueue(Message{
-			"action": "create",
-			"type":   "monster",
-			"rune":   string(monster.Rune),
-			"id":     monster.Id,
-			"pos":    monster.Pos,
-		})
-	}
+	game.EnqueueLevel()
 
 	for player := range game.Players {
 		player.Pos = Point{0, 0}
@@ -185,6 +452,7 @@ func (game *Game) MaybeNextLevel() {
 			"id":     player.Id,
 			"pos":    player.Pos,
 		})
+		player.Enqueue(game.PileContentsMessage(player.Pos))
 	}
 }
 
@@ -197,6 +465,15 @@ func (game *Game) getMonsterAt(pos Point) *Monster {
 	return nil
 }
 
+func (game *Game) getPlayer(id int) *Player {
+	for player := range game.Players {
+		if player.Id == id {
+			return player
+		}
+	}
+	return nil
+}
+
 func (game *Game) getPlayerAt(pos Point) *Player {
 	for player := range game.Players {
 		if player.Pos == pos {
@@ -216,108 +493,140 @@ func (game *Game) addToPile(pos Point, item string, amount uint) {
 		game.Piles[pos] = pile
 	}
 
-	value, ok := pile.Items[item]
-	if ok {
-		pile.Items[item] = value + amount
-	} else {
-		pile.Items[item] = amount
-		game.Enqueue(Message{
-			"action": "create",
-			"type":   "pile",
-			"id":     pile.Id,
-			"rune":   "%",
-			"pos":    pos,
-		})
+	pile.Items[item] += amount
+	game.EnqueuePileContents(pos)
+}
+
+func (game *Game) PileContentsMessage(pos Point) Message {
+	items := make(map[string]uint)
+	if pile, ok := game.Piles[pos]; ok {
+		items = maps.Clone(pile.Items)
+	}
+	return Message{
+		"action": "pileContents",
+		"items":  items,
 	}
 }
 
+func (game *Game) EnqueuePileContents(pos Point) {
+	for player := range game.Players {
+		if player.Pos == pos {
+			player.Enqueue(game.PileContentsMessage(pos))
+		}
+	}
+}
+
+func (game *Game) tick(now time.Time) bool {
+	changed := false
+	game.pathBudget = pathTickBudget
+
+	if !now.Before(game.effectsAt) {
+		game.effectsAt = now.Add(effectInterval)
+		for player := range game.Players {
+			player.TickEffects()
+		}
+		for monster := range game.Monsters {
+			monster.TickEffects()
+		}
+		changed = true
+	}
+
+	for monster := range game.Monsters {
+		if now.Before(monster.next) {
+			continue
+		}
+		changed = true
+		monster.Move()
+		monster.next = monster.next.Add(monster.interval())
+		if monster.next.Before(now) {
+			monster.next = now.Add(monster.interval())
+		}
+	}
+	return changed
+}
+
+func (game *Game) close() {
+	if verbose {
+		log.Println("remove game", game.Id)
+	}
+
+	mux.Lock()
+	delete(games, game.Id)
+	mux.Unlock()
+
+	for player := range game.Players {
+		game.removePlayer(player)
+	}
+	for player := range game.Spectators {
+		game.removeSpectator(player)
+	}
+	game.cancel()
+}
+
 func (game *Game) run() {
+	started := len(game.Players) > 0
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	defer game.close()
+
 	for {
+		var start time.Time
 		select {
 		case player := <-game.register:
-			if verbose {
-				log.Println("create player", game.Id, player.Id)
-			}
-			player.Enqueue(Message{
-				"action": "setId",
-				"id":     player.Id,
-			})
-			player.Enqueue(Message{
-				"action":      "setStats",
-				"health":      player.Health,
-				"healthTotal": player.HealthTotal,
-				"attack":      player.Attack,
-				"defense":     player.Defense,
-				"lineOfSight": player.LineOfSight,
-				"speed":       player.Speed,
-			})
-			player.Enqueue(Message{
-				"action": "setLevel",
-				"level":  game.Level,
-				"rects":  game.Rects,
-				"ladder": game.Ladder,
-			})
-			for monster := range game.Monsters {
-				player.Enqueue(Message{
-					"action": "create",
-					"type":   "monster",
-					"rune":   string(monster.Rune),
-					"id":     monster.Id,
-					"pos":    monster.Pos,
-				})
-			}
-			for pos, pile := range game.Piles {
-				player.Enqueue(Message{
-					"action": "create",
-					"type":   "pile",
-					"rune":   "%",
-					"id":     pile.Id,
-					"pos":    pos,
-				})
-			}
-			for p := range game.Players {
-				player.Enqueue(Message{
-					"action":      "create",
-					"type":        "player",
-					"rune":        "@",
-					"id":          p.Id,
-					"pos":         p.Pos,
-					"lineOfSight": p.LineOfSight,
-				})
-			}
-
-			game.Players[player] = true
-
-			game.Enqueue(Message{
-				"action":      "create",
-				"type":        "player",
-				"rune":        "@",
-				"id":          player.Id,
-				"pos":         player.Pos,
-				"lineOfSight": player.LineOfSight,
-			})
+			start = time.Now()
+			game.addPlayer(player)
+			started = true
 		case player := <-game.unregister:
+			start = time.Now()
+			game.disconnectPlayer(player)
+		case player := <-game.expire:
+			start = time.Now()
 			game.removePlayer(player)
-			if len(game.Players) == 0 {
-				if verbose {
-					log.Println("remove game", game.Id)
-				}
-				mux.Lock()
-				delete(games, game.Id)
-				mux.Unlock()
+		case reply := <-game.save:
+			start = time.Now()
+			reply <- game.Snapshot()
+		case c := <-game.reload:
+			start = time.Now()
+			game.applyContent(c)
+		case cmd := <-game.admin:
+			start = time.Now()
+			cmd.Reply <- game.handleAdmin(cmd)
+			if cmd.Action == "end" {
+				game.Flush()
 				return
 			}
 		case pmsg := <-game.Msg:
-			if _, ok := game.Players[pmsg.Player]; !ok {
+			start = time.Now()
+			if _, ok := game.Players[pmsg.Player]; !ok && !game.Spectators[pmsg.Player] {
 				continue
 			}
-			if pmsg.Msg["action"] == "move" {
+			countMessage(pmsg.Msg["action"])
+			if game.Spectators[pmsg.Player] {
+				if pmsg.Msg["action"] == "rejoin" {
+					game.rejoin(pmsg.Player)
+				}
+			} else if pmsg.Msg["action"] == "move" {
 				dir, ok := pmsg.Msg["dir"].(string)
 				if ok {
 					pmsg.Player.Move(dir)
 				}
+			} else if pmsg.Msg["action"] == "fire" {
+				dir, ok := pmsg.Msg["dir"].(string)
+				if ok {
+					pmsg.Player.Fire(dir)
+				}
 			} else if pmsg.Msg["action"] == "pickup" {
-				pmsg.Player.PickupItems()
+				item, ok := pmsg.Msg["item"].(string)
+				if ok {
+					value, ok := pmsg.Msg["amount"]
+					if !ok {
+						pmsg.Player.PickupItem(item, math.MaxUint)
+					} else if amount, ok := parseAmount(value); ok {
+						pmsg.Player.PickupItem(item, amount)
+					}
+				} else {
+					pmsg.Player.PickupItems()
+				}
 			} else if pmsg.Msg["action"] == "drop" {
 				item, ok := pmsg.Msg["item"].(string)
 				if ok {
@@ -328,15 +637,53 @@ func (game *Game) run() {
 				if ok {
 					pmsg.Player.UseItem(item)
 				}
+			} else if pmsg.Msg["action"] == "buy" {
+				item, ok := pmsg.Msg["item"].(string)
+				if ok {
+					pmsg.Player.Buy(item)
+				}
+			} else if pmsg.Msg["action"] == "sell" {
+				item, ok := pmsg.Msg["item"].(string)
+				if ok {
+					pmsg.Player.Sell(item)
+				}
+			} else if pmsg.Msg["action"] == "offer" {
+				target, ok1 := pmsg.Msg["target"].(float64)
+				items, ok2 := parseItems(pmsg.Msg["items"])
+				if ok1 && ok2 {
+					pmsg.Player.Offer(int(target), items)
+				}
+			} else if pmsg.Msg["action"] == "accept" {
+				id, ok := pmsg.Msg["id"].(float64)
+				if ok {
+					pmsg.Player.CloseOffer(int(id), true)
+				}
+			} else if pmsg.Msg["action"] == "decline" {
+				id, ok := pmsg.Msg["id"].(float64)
+				if ok {
+					pmsg.Player.CloseOffer(int(id), false)
+				}
+			} else if pmsg.Msg["action"] == "say" {
+				text, ok := pmsg.Msg["text"].(string)
+				if ok {
+					pmsg.Player.Say(text)
+				}
 			} else if verbose {
 				log.Println("unknown action", pmsg.Msg)
 			}
-		case monster := <-game.MMsg:
-			if _, ok := game.Monsters[monster]; !ok {
+		case now := <-ticker.C:
+			start = time.Now()
+			if !game.tick(now) {
 				continue
 			}
-			monster.Move()
 		}
+		game.updateVisibility()
 		game.Flush()
+		game.updateMetrics()
+		observeLoop(time.Since(start))
+
+		if started && len(game.Players) == 0 {
+			return
+		}
 	}
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dial(t *testing.T, server *httptest.Server, path string) (*websocket.Conn, []Message) {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + path
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msgs := []Message{}
+	if err := conn.ReadJSON(&msgs); err != nil {
+		t.Fatal(err)
+	}
+	return conn, msgs
+}
+
+func waitForCleanup(t *testing.T, before int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mux.RLock()
+		count := len(games)
+		mux.RUnlock()
+
+		if count == 0 && runtime.NumGoroutine() <= before {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	buf := make([]byte, 1<<16)
+	buf = buf[:runtime.Stack(buf, true)]
+	t.Fatalf("goroutines survived the game:\n%s", buf)
+}
+
+func TestEmptyGameLeavesNoGoroutines(t *testing.T) {
+	timeout := sessionTimeout
+	sessionTimeout = 50 * time.Millisecond
+	defer func() { sessionTimeout = timeout }()
+
+	before := runtime.NumGoroutine()
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("GET /ws/{id}", serveWs)
+	server := httptest.NewServer(handler)
+
+	conn, _ := dial(t, server, "/ws/leak?name=test")
+	conn.WriteJSON(Message{"action": "move", "dir": "up"})
+	conn.WriteJSON(Message{"action": "move", "dir": "down"})
+	conn.Close()
+
+	server.Close()
+	waitForCleanup(t, before)
+}
+
+func TestSpectatingEmptyGame(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("GET /ws/{id}", serveWs)
+	server := httptest.NewServer(handler)
+
+	conn, msgs := dial(t, server, "/ws/empty?spectate=1")
+	if msgs[0]["action"] != "notice" {
+		t.Fatalf("expected a notice, got %v", msgs[0]["action"])
+	}
+	if lookupGame("empty") != nil {
+		t.Errorf("spectating created a game")
+	}
+	if err := conn.ReadJSON(&msgs); err == nil {
+		t.Fatalf("expected the connection to be closed, got %v", msgs)
+	}
+	conn.Close()
+
+	server.Close()
+	waitForCleanup(t, before)
+}
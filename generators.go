@@ -0,0 +1,305 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+const MIXED = "mixed"
+
+const caveWidth = 70
+const caveHeight = 40
+const caveFill = 0.45
+const caveSteps = 5
+const caveMinSize = 400
+const caveTries = 10
+const caveLakes = 3
+
+const bspWidth = 80
+const bspHeight = 50
+const bspMinSize = 12
+
+const monsterCount = 15
+
+// A Layout is the result of a Generator. Every point in Rooms and Spawns as
+// well as the Ladder and Point{0, 0} must be reachable in Grid. The first
+// room contains the spawn and the last room contains the ladder.
+type Layout struct {
+	Grid   *Grid
+	Rooms  []Rect
+	Ladder Point
+	Spawns []Point
+	Doors  bool
+}
+
+type Generator interface {
+	Generate(r *rand.Rand) Layout
+}
+
+var Generators = map[string]Generator{
+	"rooms": RoomsGenerator{},
+	"caves": CaveGenerator{},
+	"bsp":   BSPGenerator{},
+}
+
+func validateGenerator(name string) string {
+	if _, ok := Generators[name]; ok {
+		return name
+	}
+	return MIXED
+}
+
+func (game *Game) generator() Generator {
+	if g, ok := Generators[game.Generator]; ok {
+		return g
+	}
+
+	names := []string{}
+	for name := range Generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return Generators[names[game.rand.Intn(len(names))]]
+}
+
+func connect(a Point, b Point) []Rect {
+	return []Rect{
+		makeRect(a.X, a.Y, b.X, a.Y),
+		makeRect(b.X, a.Y, b.X, b.Y),
+	}
+}
+
+type RoomsGenerator struct{}
+
+func (g RoomsGenerator) Generate(r *rand.Rand) Layout {
+	prev := Rect{-3, -3, 3, 3}
+
+	layout := Layout{Doors: true}
+	layout.Rooms = []Rect{prev}
+	lines := []Rect{}
+
+	for i := 1; i <= monsterCount; i++ {
+		rect := randomRect(r, 25)
+		if rect.Area() < 150 && rect.Perimeter() < 80 {
+			layout.Rooms = append(layout.Rooms, rect)
+			lines = append(lines, connect(prev.Center(), rect.Center())...)
+			layout.Spawns = append(layout.Spawns, rect.RandomPoint(r))
+			prev = rect
+		}
+	}
+
+	layout.Ladder = prev.RandomPoint(r)
+	layout.Grid = gridFromRects(append(lines, layout.Rooms...))
+	return layout
+}
+
+type CaveGenerator struct{}
+
+func (g CaveGenerator) Generate(r *rand.Rand) Layout {
+	for i := 0; i < caveTries; i++ {
+		if layout, ok := g.generate(r); ok {
+			return layout
+		}
+	}
+	return RoomsGenerator{}.Generate(r)
+}
+
+func (g CaveGenerator) generate(r *rand.Rand) (Layout, bool) {
+	x0 := -caveWidth / 2
+	y0 := -caveHeight / 2
+	spawn := Point{-x0, -y0}
+
+	floor := make([][]bool, caveHeight)
+	for y := range floor {
+		floor[y] = make([]bool, caveWidth)
+		for x := range floor[y] {
+			floor[y][x] = r.Float64() >= caveFill
+		}
+	}
+
+	walls := func(x, y int) int {
+		count := 0
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				xx, yy := x+dx, y+dy
+				if xx < 0 || yy < 0 || xx >= caveWidth || yy >= caveHeight || !floor[yy][xx] {
+					count += 1
+				}
+			}
+		}
+		return count
+	}
+
+	for step := 0; step < caveSteps; step++ {
+		next := make([][]bool, caveHeight)
+		for y := range next {
+			next[y] = make([]bool, caveWidth)
+			for x := range next[y] {
+				next[y][x] = walls(x, y) < 5
+			}
+		}
+		floor = next
+	}
+
+	water := make(map[Point]bool)
+	for i := 0; i < caveLakes; i++ {
+		c := Point{r.Intn(caveWidth), r.Intn(caveHeight)}
+		radius := 2 + r.Intn(2)
+		for y := c.Y - radius; y <= c.Y+radius; y++ {
+			for x := c.X - radius; x <= c.X+radius; x++ {
+				p := Point{x, y}
+				if p.Dist(c) <= radius {
+					water[p] = true
+				}
+			}
+		}
+	}
+
+	for y := spawn.Y - 1; y <= spawn.Y+1; y++ {
+		for x := spawn.X - 1; x <= spawn.X+1; x++ {
+			floor[y][x] = true
+			delete(water, Point{x, y})
+		}
+	}
+
+	// keep only the part that is reachable from the spawn
+	distance := map[Point]int{spawn: 0}
+	queue := []Point{spawn}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, dir := range dirs {
+			q := p.Move(dir)
+			if q.X < 0 || q.Y < 0 || q.X >= caveWidth || q.Y >= caveHeight {
+				continue
+			}
+			if _, ok := distance[q]; ok || !floor[q.Y][q.X] || water[q] {
+				continue
+			}
+			distance[q] = distance[p] + 1
+			queue = append(queue, q)
+		}
+	}
+	if len(distance) < caveMinSize {
+		return Layout{}, false
+	}
+
+	shift := func(p Point) Point {
+		return Point{p.X + x0, p.Y + y0}
+	}
+
+	layout := Layout{}
+	layout.Grid = makeGrid(Rect{x0, y0, x0 + caveWidth - 1, y0 + caveHeight - 1})
+
+	cells := []Point{}
+	ladder := spawn
+	for y := 0; y < caveHeight; y++ {
+		for x := 0; x < caveWidth; x++ {
+			p := Point{x, y}
+			if d, ok := distance[p]; ok {
+				layout.Grid.Set(shift(p), FLOOR)
+				cells = append(cells, p)
+				if d > distance[ladder] {
+					ladder = p
+				}
+			}
+		}
+	}
+
+	// only keep water that borders on the reachable cave
+	for p := range water {
+		for _, dir := range dirs {
+			if _, ok := distance[p.Move(dir)]; ok {
+				layout.Grid.Set(shift(p), WATER)
+				break
+			}
+		}
+	}
+
+	layout.Rooms = []Rect{Rect{-1, -1, 1, 1}}
+	seen := make(map[Point]bool)
+	for i := 0; i < monsterCount; i++ {
+		p := cells[r.Intn(len(cells))]
+		if distance[p] > 5 && p != ladder && !seen[p] {
+			seen[p] = true
+			layout.Spawns = append(layout.Spawns, shift(p))
+			layout.Rooms = append(layout.Rooms, Rect{p.X + x0, p.Y + y0, p.X + x0, p.Y + y0})
+		}
+	}
+
+	layout.Ladder = shift(ladder)
+	room := Rect{layout.Ladder.X, layout.Ladder.Y, layout.Ladder.X, layout.Ladder.Y}
+	layout.Rooms = append(layout.Rooms, room.Expand(3))
+	return layout, true
+}
+
+type BSPGenerator struct{}
+
+func (g BSPGenerator) split(r *rand.Rand, area Rect, rooms *[]Rect, lines *[]Rect) Point {
+	w := area.X2 - area.X1
+	h := area.Y2 - area.Y1
+
+	if w < 2*bspMinSize && h < 2*bspMinSize {
+		x1 := area.X1 + 1 + r.Intn(w/3)
+		y1 := area.Y1 + 1 + r.Intn(h/3)
+		x2 := area.X2 - 1 - r.Intn(w/3)
+		y2 := area.Y2 - 1 - r.Intn(h/3)
+		room := Rect{x1, y1, x2, y2}
+		*rooms = append(*rooms, room)
+		return room.Center()
+	}
+
+	var a, b Rect
+	if w > h {
+		x := area.X1 + bspMinSize + r.Intn(w-2*bspMinSize+1)
+		a = Rect{area.X1, area.Y1, x, area.Y2}
+		b = Rect{x, area.Y1, area.X2, area.Y2}
+	} else {
+		y := area.Y1 + bspMinSize + r.Intn(h-2*bspMinSize+1)
+		a = Rect{area.X1, area.Y1, area.X2, y}
+		b = Rect{area.X1, y, area.X2, area.Y2}
+	}
+
+	p1 := g.split(r, a, rooms, lines)
+	p2 := g.split(r, b, rooms, lines)
+	*lines = append(*lines, connect(p1, p2)...)
+	return p1
+}
+
+func (g BSPGenerator) Generate(r *rand.Rand) Layout {
+	rooms := []Rect{}
+	lines := []Rect{}
+	g.split(r, Rect{0, 0, bspWidth, bspHeight}, &rooms, &lines)
+
+	// move the first room to the spawn and the farthest room to the end
+	first := rooms[r.Intn(len(rooms))]
+	offset := first.Center()
+	sort.SliceStable(rooms, func(i, j int) bool {
+		ci := rooms[i].Center()
+		cj := rooms[j].Center()
+		return ci.Dist(offset) < cj.Dist(offset)
+	})
+
+	shift := func(rect Rect) Rect {
+		return Rect{rect.X1 - offset.X, rect.Y1 - offset.Y, rect.X2 - offset.X, rect.Y2 - offset.Y}
+	}
+
+	layout := Layout{Doors: true}
+	rects := []Rect{}
+	for i, room := range rooms {
+		room = shift(room)
+		layout.Rooms = append(layout.Rooms, room)
+		rects = append(rects, room)
+		if i > 0 {
+			layout.Spawns = append(layout.Spawns, room.RandomPoint(r))
+		}
+	}
+	for _, line := range lines {
+		rects = append(rects, shift(line))
+	}
+	layout.Grid = gridFromRects(rects)
+
+	last := layout.Rooms[len(layout.Rooms)-1]
+	layout.Ladder = last.RandomPoint(r)
+	return layout
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type levelSummary struct {
+	Grid   GridData
+	Ladder Point
+	Spawns []string
+	Shop   *Shop
+}
+
+func summarizeLevel(seed int64, generator string, level uint) levelSummary {
+	game := makeGame("test", seed, generator)
+	game.Level = level
+	game.generateMap()
+
+	spawns := []string{}
+	for monster := range game.Monsters {
+		spawns = append(spawns, fmt.Sprintf("%c %d %d", monster.Rune, monster.Pos.X, monster.Pos.Y))
+	}
+	sort.Strings(spawns)
+
+	return levelSummary{game.Grid.Data(), game.Ladder, spawns, game.Shop}
+}
+
+func TestSameSeedSameLevel(t *testing.T) {
+	for _, generator := range []string{"rooms", "caves", "bsp", MIXED} {
+		for level := uint(1); level <= 5; level++ {
+			a := summarizeLevel(42, generator, level)
+			b := summarizeLevel(42, generator, level)
+			if !reflect.DeepEqual(a, b) {
+				t.Errorf("%s level %d differs for the same seed", generator, level)
+			}
+		}
+	}
+
+	if reflect.DeepEqual(summarizeLevel(1, "rooms", 1), summarizeLevel(2, "rooms", 1)) {
+		t.Errorf("different seeds produced the same level")
+	}
+}
+
+func TestAIDoesNotChangeLoot(t *testing.T) {
+	a := makeGame("a", 42, "rooms")
+	a.generateMap()
+	b := makeGame("b", 42, "rooms")
+	b.generateMap()
+
+	for i := 0; i < 10; i++ {
+		RandomDir(a.aiRand)
+	}
+	for i := 0; i < 10; i++ {
+		if a.content.RandomItem(a.lootRand) != b.content.RandomItem(b.lootRand) {
+			t.Fatalf("loot depends on AI rolls")
+		}
+	}
+}
@@ -66,11 +66,11 @@ func makeRect(x1 int, y1 int, x2 int, y2 int) Rect {
 	return Rect{x1, y1, x2, y2}
 }
 
-func randomRect(n int) Rect {
-	x1 := rand.Intn(2*n) - n
-	x2 := rand.Intn(2*n) - n
-	y1 := rand.Intn(2*n) - n
-	y2 := rand.Intn(2*n) - n
+func randomRect(r *rand.Rand, n int) Rect {
+	x1 := r.Intn(2*n) - n
+	x2 := r.Intn(2*n) - n
+	y1 := r.Intn(2*n) - n
+	y2 := r.Intn(2*n) - n
 	return makeRect(x1, y1, x2, y2)
 }
 
@@ -86,6 +86,10 @@ func (rect *Rect) Contains(p Point) bool {
 	return p.X >= rect.X1 && p.X <= rect.X2 && p.Y >= rect.Y1 && p.Y <= rect.Y2
 }
 
+func (rect *Rect) Expand(n int) Rect {
+	return Rect{rect.X1 - n, rect.Y1 - n, rect.X2 + n, rect.Y2 + n}
+}
+
 func (rect *Rect) Center() Point {
 	return Point{
 		(rect.X2 + rect.X1) / 2,
@@ -93,13 +97,13 @@ func (rect *Rect) Center() Point {
 	}
 }
 
-func (rect *Rect) RandomPoint() Point {
+func (rect *Rect) RandomPoint(r *rand.Rand) Point {
 	return Point{
-		rect.X1 + rand.Intn(rect.X2-rect.X1+1),
-		rect.Y1 + rand.Intn(rect.Y2-rect.Y1+1),
+		rect.X1 + r.Intn(rect.X2-rect.X1+1),
+		rect.Y1 + r.Intn(rect.Y2-rect.Y1+1),
 	}
 }
 
-func RandomDir() string {
-	return dirs[rand.Intn(4)]
+func RandomDir(r *rand.Rand) string {
+	return dirs[r.Intn(4)]
 }
@@ -1,22 +1,36 @@
 package main
 
-import "math/rand"
+import (
+	"math/rand"
+	"sort"
+)
 
 const (
 	CONSUMABLE uint = 1
 	WEAPON          = 2
 	ARMOR           = 3
+	RANGED          = 4
+	AMMO            = 5
+	KEY             = 6
 )
 
+const GOLD = "Gold"
+const DOOR_KEY = "Key"
+const ammoDrop = 5
+
 type Item struct {
-	Type        uint    `json:"type"`
-	Value       uint    `json:"value"`
-	Health      uint    `json:"health"`
-	HealthTotal uint    `json:"healthTotal"`
-	Attack      float64 `json:"attack"`
-	Defense     float64 `json:"defense"`
-	LineOfSight int     `json:"lineOfSight"`
-	Speed       int     `json:"speed"`
+	Type        uint        `json:"type"`
+	Value       uint        `json:"value"`
+	Health      uint        `json:"health"`
+	HealthTotal uint        `json:"healthTotal"`
+	Attack      float64     `json:"attack"`
+	Defense     float64     `json:"defense"`
+	LineOfSight int         `json:"lineOfSight"`
+	Speed       int         `json:"speed"`
+	Effect      *EffectSpec `json:"effect,omitempty"`
+	Damage      float64     `json:"damage,omitempty"`
+	Range       int         `json:"range,omitempty"`
+	Ammo        string      `json:"ammo,omitempty"`
 }
 
 var Items = map[string]Item{
@@ -36,6 +50,16 @@ var Items = map[string]Item{
 		Value:  400,
 		Health: 100,
 	},
+	"Haste Potion": Item{
+		Type:   CONSUMABLE,
+		Value:  150,
+		Effect: &EffectSpec{HASTE, 10, 30},
+	},
+	"Regeneration Potion": Item{
+		Type:   CONSUMABLE,
+		Value:  200,
+		Effect: &EffectSpec{REGENERATION, 2, 20},
+	},
 	"Small Life Elixir": Item{
 		Type:        CONSUMABLE,
 		Value:       50,
@@ -75,6 +99,12 @@ var Items = map[string]Item{
 		Attack: 4,
 		Speed:  5,
 	},
+	"Venom Dagger": Item{
+		Type:   WEAPON,
+		Value:  450,
+		Attack: 4,
+		Effect: &EffectSpec{POISON, 2, 5},
+	},
 	"Sting": Item{
 		Type:        WEAPON,
 		Value:       400,
@@ -104,6 +134,59 @@ var Items = map[string]Item{
 		LineOfSight: 1,
 	},
 
+	// ranged weapons
+	"Sling": Item{
+		Type:   RANGED,
+		Value:  80,
+		Damage: 3,
+		Range:  4,
+	},
+	"Throwing Knives": Item{
+		Type:   RANGED,
+		Value:  120,
+		Damage: 5,
+		Range:  5,
+		Ammo:   "Throwing Knives",
+	},
+	"Bow": Item{
+		Type:   RANGED,
+		Value:  250,
+		Damage: 6,
+		Range:  8,
+		Ammo:   "Arrow",
+	},
+	"Crossbow": Item{
+		Type:   RANGED,
+		Value:  700,
+		Damage: 12,
+		Range:  8,
+		Ammo:   "Bolt",
+		Speed:  -3,
+	},
+	"Wand of Frost": Item{
+		Type:   RANGED,
+		Value:  900,
+		Damage: 5,
+		Range:  6,
+		Effect: &EffectSpec{SLOW, 5, 5},
+	},
+
+	// ammo
+	"Arrow": Item{
+		Type:  AMMO,
+		Value: 15,
+	},
+	"Bolt": Item{
+		Type:  AMMO,
+		Value: 25,
+	},
+
+	// keys
+	"Key": Item{
+		Type:  KEY,
+		Value: 50,
+	},
+
 	// armor
 	"Leather Armor": Item{
 		Type:    ARMOR,
@@ -172,15 +255,21 @@ var Items = map[string]Item{
 	},
 }
 
-func RandomItem() string {
+func (c *Content) RandomItem(r *rand.Rand) string {
+	names := []string{}
 	total := 0.0
-	for _, item := range Items {
+	for name, item := range c.Items {
+		if item.Type == KEY {
+			continue
+		}
+		names = append(names, name)
 		total += 1 / float64(item.Value)
 	}
+	sort.Strings(names)
 
-	x := rand.Float64()
-	for name, item := range Items {
-		p := 1 / float64(item.Value) / total
+	x := r.Float64()
+	for _, name := range names {
+		p := 1 / float64(c.Items[name].Value) / total
 		if x < p {
 			return name
 		} else {
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var knownActions = map[string]bool{
+	"move":    true,
+	"fire":    true,
+	"pickup":  true,
+	"drop":    true,
+	"use":     true,
+	"buy":     true,
+	"sell":    true,
+	"offer":   true,
+	"accept":  true,
+	"decline": true,
+	"say":     true,
+	"rejoin":  true,
+}
+
+var loopBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5}
+
+var metrics = struct {
+	sync.Mutex
+	messages    map[string]uint64
+	loopCounts  []uint64
+	loopSum     float64
+	loopCount   uint64
+	writeErrors atomic.Uint64
+	levelUps    atomic.Uint64
+	deaths      atomic.Uint64
+}{
+	messages:   make(map[string]uint64),
+	loopCounts: make([]uint64, len(loopBuckets)),
+}
+
+func countMessage(action any) {
+	name, ok := action.(string)
+	if !ok || !knownActions[name] {
+		name = "unknown"
+	}
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	metrics.messages[name] += 1
+}
+
+func observeLoop(d time.Duration) {
+	seconds := d.Seconds()
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	for i, bucket := range loopBuckets {
+		if seconds <= bucket {
+			metrics.loopCounts[i] += 1
+		}
+	}
+	metrics.loopSum += seconds
+	metrics.loopCount += 1
+}
+
+func (game *Game) updateMetrics() {
+	connected := 0
+	for player := range game.Players {
+		if player.send != nil {
+			connected += 1
+		}
+	}
+	game.connectedCount.Store(int64(connected))
+	game.monsterCount.Store(int64(len(game.Monsters)))
+}
+
+func writeMetric(w io.Writer, name string, kind string, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+	fmt.Fprintf(w, "%s %v\n", name, value)
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	list := listGames()
+	players := int64(0)
+	monsters := int64(0)
+	for _, game := range list {
+		players += game.connectedCount.Load()
+		monsters += game.monsterCount.Load()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeMetric(w, "laneya_games", "gauge", "Number of active games.", len(list))
+	writeMetric(w, "laneya_players", "gauge", "Number of connected players.", players)
+	writeMetric(w, "laneya_monsters", "gauge", "Number of monsters alive.", monsters)
+	writeMetric(w, "laneya_write_errors_total", "counter", "Number of failed websocket writes.", metrics.writeErrors.Load())
+	writeMetric(w, "laneya_level_ups_total", "counter", "Number of levels completed.", metrics.levelUps.Load())
+	writeMetric(w, "laneya_deaths_total", "counter", "Number of player deaths.", metrics.deaths.Load())
+
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	fmt.Fprintf(w, "# HELP laneya_messages_total Number of player messages processed by action.\n")
+	fmt.Fprintf(w, "# TYPE laneya_messages_total counter\n")
+	actions := []string{}
+	for action := range metrics.messages {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		fmt.Fprintf(w, "laneya_messages_total{action=%q} %d\n", action, metrics.messages[action])
+	}
+
+	fmt.Fprintf(w, "# HELP laneya_loop_duration_seconds Time spent in one iteration of a game loop.\n")
+	fmt.Fprintf(w, "# TYPE laneya_loop_duration_seconds histogram\n")
+	for i, bucket := range loopBuckets {
+		fmt.Fprintf(w, "laneya_loop_duration_seconds_bucket{le=\"%g\"} %d\n", bucket, metrics.loopCounts[i])
+	}
+	fmt.Fprintf(w, "laneya_loop_duration_seconds_bucket{le=\"+Inf\"} %d\n", metrics.loopCount)
+	fmt.Fprintf(w, "laneya_loop_duration_seconds_sum %g\n", metrics.loopSum)
+	fmt.Fprintf(w, "laneya_loop_duration_seconds_count %d\n", metrics.loopCount)
+}
@@ -7,28 +7,46 @@ import (
 )
 
 type MonsterClass struct {
-	Rune          rune
-	HealthBase    float64
-	HealthFactor  float64
-	AttackBase    float64
-	AttackFactor  float64
-	DefenseBase   float64
-	DefenseFactor float64
-	Speed         int
-	Probability   float64
+	Rune          rune        `json:"rune"`
+	HealthBase    float64     `json:"healthBase"`
+	HealthFactor  float64     `json:"healthFactor"`
+	AttackBase    float64     `json:"attackBase"`
+	AttackFactor  float64     `json:"attackFactor"`
+	DefenseBase   float64     `json:"defenseBase"`
+	DefenseFactor float64     `json:"defenseFactor"`
+	Speed         int         `json:"speed"`
+	Probability   float64     `json:"probability"`
+	XP            float64     `json:"xp"`
+	Effect        *EffectSpec `json:"effect,omitempty"`
+	MinLevel      uint        `json:"minLevel,omitempty"`
+	Range         int         `json:"range,omitempty"`
+	Summon        rune        `json:"summon,omitempty"`
+	SummonChance  float64     `json:"summonChance,omitempty"`
+	Splits        bool        `json:"splits,omitempty"`
+	FleeBelow     float64     `json:"fleeBelow,omitempty"`
+	Steals        bool        `json:"steals,omitempty"`
+	Drops         string      `json:"drops,omitempty"`
+	Boss          bool        `json:"boss,omitempty"`
 }
 
 type Monster struct {
-	Game    *Game
-	quit    chan bool
-	Id      int
-	Rune    rune
-	Pos     Point
-	Dir     string
-	Health  float64
-	Attack  float64
-	Defense float64
-	Speed   int
+	Game      *Game `json:"-"`
+	next      time.Time
+	attacker  int
+	Id        int             `json:"id"`
+	Class     *MonsterClass   `json:"-"`
+	Rune      rune            `json:"rune"`
+	Pos       Point           `json:"pos"`
+	Dir       string          `json:"dir"`
+	Health    float64         `json:"health"`
+	MaxHealth float64         `json:"maxHealth"`
+	Attack    float64         `json:"attack"`
+	Defense   float64         `json:"defense"`
+	Speed     int             `json:"speed"`
+	XP        uint            `json:"xp"`
+	Effects   Effects         `json:"effects"`
+	Loot      map[string]uint `json:"loot"`
+	Summons   int             `json:"summons"`
 }
 
 var MonsterClasses = []MonsterClass{
@@ -40,6 +58,7 @@ var MonsterClasses = []MonsterClass{
 		AttackFactor: 1,
 		DefenseBase:  2,
 		Probability:  5,
+		XP:           5,
 	},
 	MonsterClass{
 		Rune:         'M',
@@ -50,6 +69,7 @@ var MonsterClasses = []MonsterClass{
 		DefenseBase:  8,
 		Speed:        -2,
 		Probability:  1,
+		XP:           20,
 	},
 	MonsterClass{
 		Rune:         's',
@@ -60,6 +80,8 @@ var MonsterClasses = []MonsterClass{
 		DefenseBase:  2,
 		Speed:        10,
 		Probability:  2,
+		XP:           4,
+		Effect:       &EffectSpec{POISON, 1, 5},
 	},
 	MonsterClass{
 		Rune:         'z',
@@ -70,101 +92,309 @@ var MonsterClasses = []MonsterClass{
 		DefenseBase:  4,
 		Speed:        -5,
 		Probability:  2,
+		XP:           8,
+		Effect:       &EffectSpec{SLOW, 5, 3},
+	},
+	MonsterClass{
+		Rune:         'a',
+		HealthBase:   8,
+		HealthFactor: 0.8,
+		AttackBase:   3,
+		AttackFactor: 1,
+		DefenseBase:  1,
+		Probability:  2,
+		XP:           8,
+		MinLevel:     2,
+		Range:        5,
+	},
+	MonsterClass{
+		Rune:         'j',
+		HealthBase:   16,
+		HealthFactor: 2,
+		AttackBase:   3,
+		AttackFactor: 1,
+		Speed:        -3,
+		Probability:  2,
+		XP:           6,
+		MinLevel:     2,
+		Splits:       true,
+	},
+	MonsterClass{
+		Rune:         'g',
+		HealthBase:   8,
+		HealthFactor: 1,
+		AttackBase:   1,
+		AttackFactor: 0.5,
+		DefenseBase:  2,
+		Speed:        8,
+		Probability:  1,
+		XP:           10,
+		MinLevel:     2,
+		FleeBelow:    0.5,
+		Steals:       true,
+	},
+	MonsterClass{
+		Rune:         'S',
+		HealthBase:   15,
+		HealthFactor: 1.5,
+		AttackBase:   2,
+		AttackFactor: 0.5,
+		DefenseBase:  3,
+		Probability:  1,
+		XP:           15,
+		MinLevel:     3,
+		Summon:       'm',
+		SummonChance: 0.1,
+		FleeBelow:    0.3,
+	},
+	MonsterClass{
+		Rune:          'k',
+		HealthBase:    10,
+		HealthFactor:  1,
+		AttackBase:    2,
+		AttackFactor:  1,
+		DefenseBase:   3,
+		DefenseFactor: 0.5,
+		XP:            8,
+		Drops:         DOOR_KEY,
+	},
+	MonsterClass{
+		Rune:          'D',
+		HealthBase:    60,
+		HealthFactor:  8,
+		AttackBase:    10,
+		AttackFactor:  2,
+		DefenseBase:   10,
+		DefenseFactor: 1,
+		XP:            40,
+		Effect:        &EffectSpec{POISON, 2, 5},
+		Range:         4,
+		Summon:        'm',
+		SummonChance:  0.1,
+		Boss:          true,
 	},
 }
 
-func randomMonsterClass() *MonsterClass {
+func (content *Content) findMonsterClass(r rune) *MonsterClass {
+	for i := range content.MonsterClasses {
+		if content.MonsterClasses[i].Rune == r {
+			return &content.MonsterClasses[i]
+		}
+	}
+	return nil
+}
+
+func (content *Content) randomMonsterClass(r *rand.Rand, level uint) *MonsterClass {
 	total := 0.0
-	for _, c := range MonsterClasses {
-		total += c.Probability
+	for _, c := range content.MonsterClasses {
+		if c.MinLevel <= level && !c.Boss {
+			total += c.Probability
+		}
 	}
 
-	x := rand.Float64()
-	for _, c := range MonsterClasses {
+	x := r.Float64()
+	for i, c := range content.MonsterClasses {
+		if c.MinLevel > level || c.Boss {
+			continue
+		}
 		p := c.Probability / total
 		if x < p {
-			return &c
+			return &content.MonsterClasses[i]
 		} else {
 			x -= p
 		}
 	}
-	return &MonsterClasses[0]
+	return &content.MonsterClasses[0]
 }
 
-func makeMonster(game *Game, pos Point) *Monster {
+func makeMonster(game *Game, c *MonsterClass, pos Point) *Monster {
 	f := float64(game.Level)
-	c := randomMonsterClass()
 
 	monster := &Monster{
-		Game:    game,
-		quit:    make(chan bool),
-		Id:      game.createId(),
-		Rune:    c.Rune,
-		Pos:     pos,
-		Dir:     "right",
-		Health:  c.HealthBase + c.HealthFactor*f,
-		Attack:  c.AttackBase + c.AttackFactor*f,
-		Defense: c.DefenseBase + c.DefenseFactor*f,
-		Speed:   c.Speed,
-	}
-
-	go monster.run()
+		Game:      game,
+		Id:        game.createId(),
+		Class:     c,
+		Rune:      c.Rune,
+		Pos:       pos,
+		Dir:       "right",
+		Health:    c.HealthBase + c.HealthFactor*f,
+		MaxHealth: c.HealthBase + c.HealthFactor*f,
+		Attack:    c.AttackBase + c.AttackFactor*f,
+		Defense:   c.DefenseBase + c.DefenseFactor*f,
+		Speed:     c.Speed,
+		XP:        uint(math.Round(c.XP * f)),
+		Effects:   make(Effects),
+		Loot:      make(map[string]uint),
+	}
+	monster.next = time.Now().Add(monster.interval())
+
 	return monster
 }
 
-func (monster *Monster) run() {
+func (monster *Monster) CreateMessage() Message {
+	return Message{
+		"action": "create",
+		"type":   "monster",
+		"rune":   string(monster.Rune),
+		"id":     monster.Id,
+		"pos":    monster.Pos,
+	}
+}
+
+func (monster *Monster) interval() time.Duration {
 	frequency := 2 * math.Pow(1.07, float64(monster.Speed))
-	timeout := time.Duration(float64(time.Second) / frequency)
-	ticker := time.NewTicker(timeout)
-	defer ticker.Stop()
+	return time.Duration(float64(time.Second) / frequency)
+}
 
-	for {
-		select {
-		case <-monster.quit:
-			return
-		case <-ticker.C:
-			monster.Game.MMsg <- monster
+func (monster *Monster) TakeDamage(attack float64, attacker *Player) {
+	monster.attacker = attacker.Id
+	monster.Hurt(attack * attack / (attack + monster.Defense))
+}
+
+func (monster *Monster) Hurt(amount float64) {
+	if amount >= monster.Health {
+		monster.Die()
+	} else {
+		monster.Health -= amount
+	}
+}
+
+func (monster *Monster) Die() {
+	game := monster.Game
+	delete(game.Monsters, monster)
+	for item, amount := range monster.Loot {
+		game.addToPile(monster.Pos, item, amount)
+	}
+	if monster.Class.Drops != "" {
+		game.addToPile(monster.Pos, monster.Class.Drops, 1)
+	}
+	if monster.Id == game.Boss {
+		monster.DefeatBoss()
+	} else if !monster.Split() {
+		item := game.content.RandomItem(game.lootRand)
+		if game.content.Items[item].Type == AMMO {
+			game.addToPile(monster.Pos, item, ammoDrop)
+		} else {
+			game.addToPile(monster.Pos, item, 1)
+		}
+		game.addToPile(monster.Pos, GOLD, uint(1+game.lootRand.Intn(5*int(game.Level))))
+	}
+	game.hide(monster.Id)
+
+	if player := game.getPlayer(monster.attacker); player != nil {
+		player.AddXP(monster.XP)
+	}
+}
+
+func (monster *Monster) EffectsMessage() Message {
+	return Message{
+		"action":  "setEffects",
+		"id":      monster.Id,
+		"effects": monster.Effects.Copy(),
+	}
+}
+
+func (monster *Monster) AddEffect(spec EffectSpec) {
+	if monster.Effects == nil {
+		monster.Effects = make(Effects)
+	}
+	monster.Speed += monster.Effects.Add(spec)
+	if monster.Game.visible[monster.Id] {
+		monster.Game.Enqueue(monster.EffectsMessage())
+	}
+}
+
+func (monster *Monster) TickEffects() {
+	if len(monster.Effects) == 0 {
+		return
+	}
+
+	health, speed := monster.Effects.Tick()
+	monster.Speed += speed
+	if monster.Game.visible[monster.Id] {
+		monster.Game.Enqueue(monster.EffectsMessage())
+	}
+
+	if health < 0 {
+		monster.Hurt(float64(-health))
+	} else {
+		monster.Health += float64(health)
+	}
+}
+
+func (monster *Monster) closestPlayer() (*Player, int) {
+	var closest *Player
+	bestDist := 100000
+	for player := range monster.Game.Players {
+		if player.send == nil {
+			continue
+		}
+		dist := monster.Pos.Dist(player.Pos)
+		if dist < bestDist {
+			bestDist = dist
+			closest = player
 		}
 	}
+	return closest, bestDist
 }
 
-func (monster *Monster) TakeDamage(attack float64) {
-	amount := attack * attack / (attack + monster.Defense)
-	if amount >= monster.Health {
-		monster.quit <- true
-		delete(monster.Game.Monsters, monster)
-		monster.Game.addToPile(monster.Pos, RandomItem(), 1)
+func (monster *Monster) setPos(pos Point) {
+	monster.Pos = pos
+	if monster.Game.visible[monster.Id] {
 		monster.Game.Enqueue(Message{
-			"action": "remove",
+			"action": "setPosition",
 			"id":     monster.Id,
+			"pos":    monster.Pos,
 		})
-	} else {
-		monster.Health -= amount
+	}
+}
+
+func (monster *Monster) Hit(player *Player) {
+	game := monster.Game
+	player.TakeDamage(monster.Attack)
+	if _, ok := game.Players[player]; !ok {
+		return
+	}
+	if monster.Class.Effect != nil {
+		player.AddEffect(*monster.Class.Effect)
+	}
+	if monster.Class.Steals {
+		monster.Steal(player)
 	}
 }
 
 func (monster *Monster) Move() {
 	game := monster.Game
+	c := monster.Class
+
+	target, dist := monster.closestPlayer()
+	if target != nil && dist <= 10 {
+		if monster.Health < c.FleeBelow*monster.MaxHealth || len(monster.Loot) > 0 {
+			monster.Flee(target)
+			return
+		}
+		if c.SummonChance > 0 && game.aiRand.Float64() < c.SummonChance && monster.DoSummon() {
+			return
+		}
+		if c.Range > 0 && monster.Shoot(target) {
+			return
+		}
+	}
 
 	pos := monster.Pos.Move(monster.Dir)
 	player := game.getPlayerAt(pos)
 
 	if player == nil {
-		bestDist := 100000
-		monster.Dir = "left"
-		for player := range game.Players {
-			dist := monster.Pos.Dist(player.Pos)
-			if dist < bestDist {
-				bestDist = dist
-				monster.Dir = monster.Pos.Dir(player.Pos)
-			}
-		}
-
-		if bestDist > 10 {
+		if target == nil || dist > 10 {
 			return
 		}
-		if !game.IsFree(monster.Pos.Move(monster.Dir)) {
-			monster.Dir = RandomDir()
+		if dir, ok := game.FindPath(monster.Pos, target.Pos); ok {
+			monster.Dir = dir
+		} else {
+			monster.Dir = monster.Pos.Dir(target.Pos)
+			if !game.IsFree(monster.Pos.Move(monster.Dir)) {
+				monster.Dir = RandomDir(game.aiRand)
+			}
 		}
 
 		pos = monster.Pos.Move(monster.Dir)
@@ -172,13 +402,10 @@ func (monster *Monster) Move() {
 	}
 
 	if player != nil {
-		player.TakeDamage(monster.Attack)
+		if player.send != nil {
+			monster.Hit(player)
+		}
 	} else if game.getMonsterAt(pos) == nil && game.IsFree(pos) {
-		monster.Pos = pos
-		game.Enqueue(Message{
-			"action": "setPosition",
-			"id":     monster.Id,
-			"pos":    monster.Pos,
-		})
+		monster.setPos(pos)
 	}
 }
@@ -0,0 +1,83 @@
+package main
+
+import "container/heap"
+
+const pathSearchLimit = 200
+const pathTickBudget = 2000
+
+type pathNode struct {
+	pos      Point
+	cost     int
+	estimate int
+	first    string
+}
+
+type pathQueue []*pathNode
+
+func (q pathQueue) Len() int {
+	return len(q)
+}
+
+func (q pathQueue) Less(i, j int) bool {
+	return q[i].estimate < q[j].estimate
+}
+
+func (q pathQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *pathQueue) Push(x any) {
+	*q = append(*q, x.(*pathNode))
+}
+
+func (q *pathQueue) Pop() any {
+	old := *q
+	n := len(old)
+	node := old[n-1]
+	*q = old[:n-1]
+	return node
+}
+
+func (game *Game) FindPath(from Point, to Point) (string, bool) {
+	if game.pathBudget <= 0 {
+		return "", false
+	}
+
+	blocked := make(map[Point]bool)
+	for monster := range game.Monsters {
+		blocked[monster.Pos] = true
+	}
+
+	seen := map[Point]bool{from: true}
+	queue := &pathQueue{&pathNode{pos: from, estimate: from.Dist(to)}}
+
+	for expanded := 0; queue.Len() > 0 && expanded < pathSearchLimit; expanded++ {
+		if game.pathBudget <= 0 {
+			break
+		}
+		game.pathBudget -= 1
+
+		node := heap.Pop(queue).(*pathNode)
+		for _, dir := range dirs {
+			pos := node.pos.Move(dir)
+			first := node.first
+			if first == "" {
+				first = dir
+			}
+			if pos == to {
+				return first, true
+			}
+			if seen[pos] || blocked[pos] || !game.IsFree(pos) {
+				continue
+			}
+			seen[pos] = true
+			heap.Push(queue, &pathNode{
+				pos:      pos,
+				cost:     node.cost + 1,
+				estimate: node.cost + 1 + pos.Dist(to),
+				first:    first,
+			})
+		}
+	}
+	return "", false
+}
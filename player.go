@@ -1,62 +1,129 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"math"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type Player struct {
-	Game        *Game
+	Game        *Game `json:"-"`
 	send        chan []Message
 	queue       []Message
 	conn        *websocket.Conn
 	alive       bool
-	Id          int
-	Pos         Point
-	Health      uint
-	HealthTotal uint
-	Attack      float64
-	Defense     float64
-	LineOfSight uint
-	Speed       int
-	Inventory   map[string]uint
-	Weapon      string
-	Armor       string
+	expire      *time.Timer
+	offers      map[int]map[string]uint
+	tiles       bool
+	spectate    bool
+	Token       string          `json:"token"`
+	Id          int             `json:"id"`
+	Name        string          `json:"name"`
+	Color       int             `json:"color"`
+	Pos         Point           `json:"pos"`
+	Health      uint            `json:"health"`
+	HealthTotal uint            `json:"healthTotal"`
+	Attack      float64         `json:"attack"`
+	Defense     float64         `json:"defense"`
+	LineOfSight uint            `json:"lineOfSight"`
+	Speed       int             `json:"speed"`
+	XP          uint            `json:"xp"`
+	Level       uint            `json:"level"`
+	Gold        uint            `json:"gold"`
+	Effects     Effects         `json:"effects"`
+	Inventory   map[string]uint `json:"inventory"`
+	Weapon      string          `json:"weapon"`
+	Armor       string          `json:"armor"`
+	Ranged      string          `json:"ranged"`
 }
 
+const maxNameLength = 16
+const xpFactor = 20
+const defaultColor = 4
+
 type PlayerMessage struct {
 	Player *Player
 	Msg    Message
 }
 
+func createToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validateName(name string) string {
+	return sanitize(name, maxNameLength)
+}
+
+func validateColor(color string) int {
+	c, err := strconv.Atoi(color)
+	if err != nil || c < 1 || c > 7 {
+		return defaultColor
+	}
+	return c
+}
+
+func (player *Player) resume(old *Player) {
+	conn := player.conn
+	send := player.send
+	tiles := player.tiles
+
+	*player = *old
+	player.conn = conn
+	player.send = send
+	player.tiles = tiles
+	player.queue = []Message{}
+	player.alive = true
+	player.expire = nil
+}
+
+func (player *Player) CreateMessage() Message {
+	return Message{
+		"action":      "create",
+		"type":        "player",
+		"rune":        "@",
+		"id":          player.Id,
+		"name":        player.Name,
+		"color":       player.Color,
+		"pos":         player.Pos,
+		"lineOfSight": player.LineOfSight,
+	}
+}
+
 func (player *Player) Enqueue(msg Message) {
 	player.queue = append(player.queue, msg)
 }
 
 func (player *Player) Flush() {
 	if len(player.queue) > 0 {
-		player.send <- player.queue
+		if player.send != nil {
+			player.send <- player.queue
+		}
 		player.queue = []Message{}
 	}
 }
 
 func (player *Player) TakeDamage(attack float64) {
-	amount := uint(math.Round(attack * attack / (attack + player.Defense)))
+	player.Hurt(uint(math.Round(attack * attack / (attack + player.Defense))))
+}
+
+func (player *Player) Hurt(amount uint) {
 	if amount >= player.Health {
-		player.Game.removePlayer(player)
+		metrics.deaths.Add(1)
+		player.Game.killPlayer(player)
 	} else {
 		player.Health -= amount
 		player.CommitStats()
 	}
 }
 
-func (player *Player) CommitStats() {
-	if player.Health > player.HealthTotal {
-		player.Health = player.HealthTotal
-	}
-
-	player.Enqueue(Message{
+func (player *Player) StatsMessage() Message {
+	return Message{
 		"action":      "setStats",
 		"health":      player.Health,
 		"healthTotal": player.HealthTotal,
@@ -64,13 +131,75 @@ func (player *Player) CommitStats() {
 		"defense":     player.Defense,
 		"lineOfSight": player.LineOfSight,
 		"speed":       player.Speed,
-	})
+		"xp":          player.XP,
+		"xpNext":      player.NextLevelXP(),
+		"level":       player.Level,
+	}
+}
+
+func (player *Player) CommitStats() {
+	if player.Health > player.HealthTotal {
+		player.Health = player.HealthTotal
+	}
+
+	player.Enqueue(player.StatsMessage())
 
 	player.Game.Enqueue(Message{
 		"action": "setLineOfSight",
 		"id":     player.Id,
 		"value":  player.LineOfSight,
 	})
+	player.Game.EnqueueRoster()
+}
+
+func (player *Player) EffectsMessage() Message {
+	return Message{
+		"action":  "setEffects",
+		"id":      player.Id,
+		"effects": player.Effects.Copy(),
+	}
+}
+
+func (player *Player) AddEffect(spec EffectSpec) {
+	if player.Effects == nil {
+		player.Effects = make(Effects)
+	}
+	player.Speed += player.Effects.Add(spec)
+	player.Enqueue(player.EffectsMessage())
+	player.CommitStats()
+}
+
+func (player *Player) TickEffects() {
+	if len(player.Effects) == 0 {
+		return
+	}
+
+	health, speed := player.Effects.Tick()
+	player.Speed += speed
+	player.Enqueue(player.EffectsMessage())
+
+	if health < 0 {
+		player.Hurt(uint(-health))
+	} else {
+		player.Health += uint(health)
+		player.CommitStats()
+	}
+}
+
+func (player *Player) NextLevelXP() uint {
+	return xpFactor * player.Level * player.Level
+}
+
+func (player *Player) AddXP(amount uint) {
+	player.XP += amount
+	for player.XP >= player.NextLevelXP() {
+		player.Level += 1
+		player.HealthTotal += 10
+		player.Health += 10
+		player.Attack += 1
+		player.Defense += 1
+	}
+	player.CommitStats()
 }
 
 func (player *Player) ApplyItem(item Item) {
@@ -91,6 +220,14 @@ func (player *Player) UnapplyItem(item Item) {
 	player.Speed -= item.Speed
 }
 
+func (player *Player) SetGold(amount uint) {
+	player.Gold = amount
+	player.Enqueue(Message{
+		"action": "setGold",
+		"amount": player.Gold,
+	})
+}
+
 func (player *Player) AddItem(name string, added uint) {
 	amount, ok := player.Inventory[name]
 	if ok {
@@ -120,7 +257,7 @@ func (player *Player) RemoveItem(name string) {
 
 		if name == player.Weapon {
 			player.Weapon = ""
-			if item, ok := Items[name]; ok {
+			if item, ok := player.Game.content.Items[name]; ok {
 				player.UnapplyItem(item)
 				player.CommitStats()
 				player.Enqueue(Message{
@@ -130,7 +267,7 @@ func (player *Player) RemoveItem(name string) {
 			}
 		} else if name == player.Armor {
 			player.Armor = ""
-			if item, ok := Items[name]; ok {
+			if item, ok := player.Game.content.Items[name]; ok {
 				player.UnapplyItem(item)
 				player.CommitStats()
 				player.Enqueue(Message{
@@ -138,6 +275,16 @@ func (player *Player) RemoveItem(name string) {
 					"item":   player.Armor,
 				})
 			}
+		} else if name == player.Ranged {
+			player.Ranged = ""
+			if item, ok := player.Game.content.Items[name]; ok {
+				player.UnapplyItem(item)
+				player.CommitStats()
+				player.Enqueue(Message{
+					"action": "setRanged",
+					"item":   player.Ranged,
+				})
+			}
 		}
 	}
 
@@ -153,7 +300,13 @@ func (player *Player) Move(dir string) {
 	pos := player.Pos.Move(dir)
 	monster := game.getMonsterAt(pos)
 	if monster != nil {
-		monster.TakeDamage(player.Attack)
+		monster.TakeDamage(player.Attack, player)
+		item, ok := player.Game.content.Items[player.Weapon]
+		if ok && item.Effect != nil && game.Monsters[monster] {
+			monster.AddEffect(*item.Effect)
+		}
+	} else if tile := game.Grid.Get(pos); tile == DOOR || tile == LOCKED {
+		player.OpenDoor(pos)
 	} else if game.IsFree(pos) {
 		player.Pos = pos
 		game.Enqueue(Message{
@@ -161,23 +314,93 @@ func (player *Player) Move(dir string) {
 			"id":     player.Id,
 			"pos":    player.Pos,
 		})
+		player.Enqueue(game.PileContentsMessage(player.Pos))
 
 		game.MaybeNextLevel()
 	}
 }
 
+func (player *Player) Fire(dir string) {
+	item, ok := player.Game.content.Items[player.Ranged]
+	if !ok {
+		return
+	}
+	if item.Ammo != "" {
+		if _, ok := player.Inventory[item.Ammo]; !ok {
+			return
+		}
+		player.RemoveItem(item.Ammo)
+	}
+
+	game := player.Game
+	pos := player.Pos
+	var target *Monster
+	for i := 0; i < min(item.Range, int(player.LineOfSight)) && target == nil; i++ {
+		next := pos.Move(dir)
+		if !game.IsFree(next) {
+			break
+		}
+		pos = next
+		target = game.getMonsterAt(pos)
+	}
+
+	game.Enqueue(Message{
+		"action": "projectile",
+		"from":   player.Pos,
+		"to":     pos,
+	})
+
+	if target != nil {
+		target.TakeDamage(item.Damage, player)
+		if item.Effect != nil && game.Monsters[target] {
+			target.AddEffect(*item.Effect)
+		}
+	}
+}
+
+func (player *Player) takeFromPile(pile *Pile, name string, amount uint) {
+	if amount > pile.Items[name] {
+		amount = pile.Items[name]
+	}
+	if amount == 0 {
+		return
+	}
+
+	pile.Items[name] -= amount
+	if pile.Items[name] == 0 {
+		delete(pile.Items, name)
+	}
+
+	if name == GOLD {
+		player.SetGold(player.Gold + amount)
+	} else {
+		player.AddItem(name, amount)
+	}
+}
+
+func (player *Player) PickupItem(name string, amount uint) {
+	game := player.Game
+	pile, ok := game.Piles[player.Pos]
+	if ok {
+		player.takeFromPile(pile, name, amount)
+		if len(pile.Items) == 0 {
+			delete(game.Piles, player.Pos)
+			game.hide(pile.Id)
+		}
+		game.EnqueuePileContents(player.Pos)
+	}
+}
+
 func (player *Player) PickupItems() {
 	game := player.Game
 	pile, ok := game.Piles[player.Pos]
 	if ok {
-		delete(game.Piles, player.Pos)
 		for item, amount := range pile.Items {
-			player.AddItem(item, amount)
+			player.takeFromPile(pile, item, amount)
 		}
-		game.Enqueue(Message{
-			"action": "remove",
-			"id":     pile.Id,
-		})
+		delete(game.Piles, player.Pos)
+		game.hide(pile.Id)
+		game.EnqueuePileContents(player.Pos)
 	}
 }
 
@@ -193,7 +416,7 @@ func (player *Player) UseItem(name string) {
 		return
 	}
 
-	item, ok := Items[name]
+	item, ok := player.Game.content.Items[name]
 	if !ok {
 		return
 	}
@@ -204,6 +427,7 @@ func (player *Player) UseItem(name string) {
 		item.Defense == 0 &&
 		item.LineOfSight == 0 &&
 		item.Speed == 0 &&
+		item.Effect == nil &&
 		player.Health == player.HealthTotal {
 		return
 	}
@@ -212,8 +436,11 @@ func (player *Player) UseItem(name string) {
 	case CONSUMABLE:
 		player.RemoveItem(name)
 		player.ApplyItem(item)
+		if item.Effect != nil {
+			player.AddEffect(*item.Effect)
+		}
 	case WEAPON:
-		if old, ok := Items[player.Weapon]; ok {
+		if old, ok := player.Game.content.Items[player.Weapon]; ok {
 			player.UnapplyItem(old)
 		}
 		if name != player.Weapon {
@@ -227,7 +454,7 @@ func (player *Player) UseItem(name string) {
 			"item":   player.Weapon,
 		})
 	case ARMOR:
-		if old, ok := Items[player.Armor]; ok {
+		if old, ok := player.Game.content.Items[player.Armor]; ok {
 			player.UnapplyItem(old)
 		}
 		if name != player.Armor {
@@ -240,7 +467,40 @@ func (player *Player) UseItem(name string) {
 			"action": "setArmor",
 			"item":   player.Armor,
 		})
+	case RANGED:
+		if old, ok := player.Game.content.Items[player.Ranged]; ok {
+			player.UnapplyItem(old)
+		}
+		if name != player.Ranged {
+			player.ApplyItem(item)
+			player.Ranged = name
+		} else {
+			player.Ranged = ""
+		}
+		player.Enqueue(Message{
+			"action": "setRanged",
+			"item":   player.Ranged,
+		})
 	}
 
 	player.CommitStats()
 }
+
+func (player *Player) reset() {
+	player.Pos = Point{0, 0}
+	player.Health = 100
+	player.HealthTotal = 100
+	player.Attack = 5
+	player.Defense = 0
+	player.LineOfSight = 5
+	player.Speed = 0
+	player.XP = 0
+	player.Level = 1
+	player.Gold = 0
+	player.Inventory = make(map[string]uint)
+	player.Weapon = ""
+	player.Armor = ""
+	player.Ranged = ""
+	player.Effects = make(Effects)
+	player.offers = nil
+}
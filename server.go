@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"math/rand"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -22,12 +24,17 @@ var upgrader = websocket.Upgrader{}
 func (player *Player) readPump() {
 	var timer *time.Timer = nil
 	lastTime := time.UnixMicro(0)
+	chatBudget := float64(chatBurst)
+	lastChat := time.Now()
 
 	defer func() {
 		if timer != nil {
 			timer.Stop()
 		}
-		player.Game.unregister <- player
+		select {
+		case player.Game.unregister <- player:
+		case <-player.Game.ctx.Done():
+		}
 		player.conn.Close()
 	}()
 
@@ -41,6 +48,27 @@ func (player *Player) readPump() {
 			return
 		}
 
+		if msg["action"] == "say" {
+			now := time.Now()
+			chatBudget += now.Sub(lastChat).Seconds() / chatInterval.Seconds()
+			chatBudget = math.Min(chatBudget, chatBurst)
+			lastChat = now
+
+			if chatBudget < 1 {
+				if verbose {
+					log.Println("rate limited chat", player.Id)
+				}
+				continue
+			}
+			chatBudget -= 1
+
+			select {
+			case player.Game.Msg <- PlayerMessage{player, msg}:
+			case <-player.Game.ctx.Done():
+			}
+			continue
+		}
+
 		if timer != nil {
 			timer.Stop()
 		}
@@ -48,31 +76,35 @@ func (player *Player) readPump() {
 		timeout := time.Duration(float64(time.Second) / frequency)
 		timer = time.AfterFunc(time.Until(lastTime.Add(timeout)), func() {
 			lastTime = time.Now()
-			player.Game.Msg <- PlayerMessage{player, msg}
+			select {
+			case player.Game.Msg <- PlayerMessage{player, msg}:
+			case <-player.Game.ctx.Done():
+			}
 			timer = nil
 		})
 	}
 }
 
-func (player *Player) writePump() {
+func (player *Player) writePump(send chan []Message) {
 	defer player.conn.Close()
 	ticker := time.NewTicker(20 * time.Second)
 
 	defer func() {
 		ticker.Stop()
-		for _ = range player.send {
+		for _ = range send {
 			// drain
 		}
 	}()
 
 	for {
 		select {
-		case data, ok := <-player.send:
+		case data, ok := <-send:
 			if !ok {
 				return
 			}
 			err := player.conn.WriteJSON(data)
 			if err != nil {
+				metrics.writeErrors.Add(1)
 				if verbose {
 					log.Println(err)
 				}
@@ -85,6 +117,7 @@ func (player *Player) writePump() {
 			player.alive = false
 			err := player.conn.WriteMessage(websocket.PingMessage, nil)
 			if err != nil {
+				metrics.writeErrors.Add(1)
 				if verbose {
 					log.Println(err)
 				}
@@ -103,41 +136,60 @@ func serveWs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	game := getGame(r.PathValue("id"))
-
+	seed, err := strconv.ParseInt(r.URL.Query().Get("seed"), 10, 64)
+	if err != nil {
+		seed = int64(rand.Int31())
+	}
+	generator := r.URL.Query().Get("generator")
 	player := &Player{
-		Game:        game,
-		send:        make(chan []Message, 5),
-		queue:       []Message{},
-		conn:        conn,
-		alive:       true,
-		Id:          game.createId(),
-		Pos:         Point{0, 0},
-		Health:      100,
-		HealthTotal: 100,
-		Attack:      5,
-		Defense:     0,
-		LineOfSight: 5,
-		Speed:       0,
-		Inventory:   make(map[string]uint),
+		send:     make(chan []Message, 5),
+		queue:    []Message{},
+		conn:     conn,
+		alive:    true,
+		tiles:    r.URL.Query().Get("tiles") != "",
+		spectate: r.URL.Query().Get("spectate") != "",
+		Token:    r.URL.Query().Get("token"),
+		Name:     validateName(r.URL.Query().Get("name")),
+		Color:    validateColor(r.URL.Query().Get("color")),
 	}
+	player.reset()
 	conn.SetPongHandler(func(string) error {
 		player.alive = true
 		return nil
 	})
-	game.register <- player
 
-	go player.writePump()
-	go player.readPump()
+	for {
+		var game *Game
+		if player.spectate {
+			game = lookupGame(r.PathValue("id"))
+			if game == nil {
+				conn.WriteJSON([]Message{{"action": "notice", "text": nobodyToWatch}})
+				conn.Close()
+				return
+			}
+		} else {
+			game = getGame(r.PathValue("id"), seed, generator)
+		}
+		player.Game = game
+		select {
+		case game.register <- player:
+			return
+		case <-game.ctx.Done():
+		}
+	}
 }
 
 func serveItems(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(Items)
+	json.NewEncoder(w).Encode(currentContent().Items)
 }
 
 func serve(addr string) {
 	http.HandleFunc("GET /ws/{id}", serveWs)
+	http.HandleFunc("GET /metrics", serveMetrics)
+	if adminToken != "" {
+		registerAdmin()
+	}
 
 	ctx, unregisterSignals := signal.NotifyContext(
 		context.Background(), os.Interrupt, syscall.SIGTERM,
@@ -153,7 +205,20 @@ func serve(addr string) {
 		}
 	}()
 
-	<-ctx.Done()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for ctx.Err() == nil {
+		select {
+		case <-hup:
+			log.Println("Reloading content…")
+			if err := reloadContent(); err != nil {
+				log.Println(err)
+			}
+		case <-ctx.Done():
+		}
+	}
 	unregisterSignals()
 	log.Println("Shutting down server…")
 	server.Shutdown(context.Background())
@@ -161,19 +226,40 @@ func serve(addr string) {
 
 func main() {
 	dumpItems := false
+	dumpMonsters := false
+	stateFile := ""
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "laneya [-v] [-s] [--dump-items] [port]\n")
+		fmt.Fprintf(os.Stderr, "laneya [-v] [-s] [-state file] [-items file] [-monsters file] [-admin-token token] [--dump-items] [--dump-monsters] [port]\n")
 		flag.PrintDefaults()
 	}
 
 	flag.BoolVar(&verbose, "v", false, "enable verbose logs")
 	flag.BoolVar(&static, "s", false, "serve static files (for development)")
 	flag.BoolVar(&dumpItems, "dump-items", false, "dump items.json and exit")
+	flag.BoolVar(&dumpMonsters, "dump-monsters", false, "dump monsters.json and exit")
+	flag.StringVar(&itemsFile, "items", "", "load item definitions from this file instead of the built-in ones")
+	flag.StringVar(&monstersFile, "monsters", "", "load monster definitions from this file instead of the built-in ones")
+	flag.StringVar(&adminToken, "admin-token", "", "enable the admin API under /admin/ with this bearer token (default $LANEYA_ADMIN_TOKEN)")
+	flag.StringVar(&stateFile, "state", "", "save running games to this file on shutdown and restore them on startup")
 	flag.Parse()
 
+	if adminToken == "" {
+		adminToken = os.Getenv("LANEYA_ADMIN_TOKEN")
+	}
+
+	c, err := loadContent()
+	if err != nil {
+		log.Fatal(err)
+	}
+	content = c
+
 	if dumpItems {
-		json.NewEncoder(os.Stdout).Encode(Items)
+		json.NewEncoder(os.Stdout).Encode(content.Items)
+		return
+	}
+	if dumpMonsters {
+		json.NewEncoder(os.Stdout).Encode(content.MonsterClasses)
 		return
 	}
 
@@ -190,5 +276,19 @@ func main() {
 		http.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 	}
 
+	if stateFile != "" {
+		err := loadGames(stateFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	serve(addr)
+
+	if stateFile != "" {
+		err := saveGames(stateFile)
+		if err != nil {
+			log.Println(err)
+		}
+	}
 }
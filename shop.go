@@ -0,0 +1,87 @@
+package main
+
+import "sort"
+
+const shopChance = 0.3
+const shopSize = 5
+
+type Shop struct {
+	Pos   Point    `json:"pos"`
+	Items []string `json:"items"`
+}
+
+func sellPrice(item Item) uint {
+	return item.Value / 2
+}
+
+func (game *Game) generateShop(rooms []Rect) {
+	game.Shop = nil
+	if len(rooms) < 3 || game.rand.Float64() >= shopChance {
+		return
+	}
+
+	// skip the first room (spawn) and the last room (ladder)
+	rect := rooms[1+game.rand.Intn(len(rooms)-2)]
+	shop := &Shop{
+		Pos:   rect.RandomPoint(game.rand),
+		Items: []string{},
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10*shopSize && len(shop.Items) < shopSize; i++ {
+		name := game.content.RandomItem(game.rand)
+		if !seen[name] {
+			seen[name] = true
+			shop.Items = append(shop.Items, name)
+		}
+	}
+	sort.Slice(shop.Items, func(i, j int) bool {
+		return game.content.Items[shop.Items[i]].Value < game.content.Items[shop.Items[j]].Value
+	})
+
+	game.Shop = shop
+}
+
+func (game *Game) inShop(pos Point, name string) bool {
+	if game.Shop == nil || game.Shop.Pos != pos {
+		return false
+	}
+	for _, item := range game.Shop.Items {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (player *Player) Buy(name string) {
+	if !player.Game.inShop(player.Pos, name) {
+		return
+	}
+
+	item, ok := player.Game.content.Items[name]
+	if !ok || player.Gold < item.Value {
+		return
+	}
+
+	player.SetGold(player.Gold - item.Value)
+	player.AddItem(name, 1)
+}
+
+func (player *Player) Sell(name string) {
+	shop := player.Game.Shop
+	if shop == nil || shop.Pos != player.Pos {
+		return
+	}
+	if _, ok := player.Inventory[name]; !ok {
+		return
+	}
+
+	item, ok := player.Game.content.Items[name]
+	if !ok {
+		return
+	}
+
+	player.RemoveItem(name)
+	player.SetGold(player.Gold + sellPrice(item))
+}
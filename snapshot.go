@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"time"
+)
+
+type PileSnapshot struct {
+	Pos   Point           `json:"pos"`
+	Id    int             `json:"id"`
+	Items map[string]uint `json:"items"`
+}
+
+type GameSnapshot struct {
+	Id        string         `json:"id"`
+	LastId    int            `json:"lastId"`
+	Seed      int64          `json:"seed"`
+	Generator string         `json:"generator"`
+	Level     uint           `json:"level"`
+	Grid      GridData       `json:"grid"`
+	Rects     []Rect         `json:"rects,omitempty"`
+	Ladder    Point          `json:"ladder"`
+	Boss      int            `json:"boss"`
+	Shop      *Shop          `json:"shop"`
+	Piles     []PileSnapshot `json:"piles"`
+	Monsters  []Monster      `json:"monsters"`
+	Players   []Player       `json:"players"`
+}
+
+func (game *Game) Snapshot() GameSnapshot {
+	snapshot := GameSnapshot{
+		Id:        game.Id,
+		LastId:    game.lastId,
+		Seed:      game.Seed,
+		Generator: game.Generator,
+		Level:     game.Level,
+		Grid:      game.Grid.Data(),
+		Ladder:    game.Ladder,
+		Boss:      game.Boss,
+		Shop:      game.Shop,
+		Piles:     []PileSnapshot{},
+		Monsters:  []Monster{},
+		Players:   []Player{},
+	}
+
+	for pos, pile := range game.Piles {
+		snapshot.Piles = append(snapshot.Piles, PileSnapshot{
+			Pos:   pos,
+			Id:    pile.Id,
+			Items: maps.Clone(pile.Items),
+		})
+	}
+	for monster := range game.Monsters {
+		m := *monster
+		m.Effects = monster.Effects.Clone()
+		m.Loot = maps.Clone(monster.Loot)
+		snapshot.Monsters = append(snapshot.Monsters, m)
+	}
+	for player := range game.Players {
+		p := *player
+		p.Inventory = maps.Clone(player.Inventory)
+		p.Effects = player.Effects.Clone()
+		snapshot.Players = append(snapshot.Players, p)
+	}
+
+	return snapshot
+}
+
+func restoreGame(snapshot GameSnapshot) (*Game, error) {
+	var grid *Grid
+	if snapshot.Grid.Width > 0 && snapshot.Grid.Height > 0 {
+		var err error
+		grid, err = snapshot.Grid.Grid()
+		if err != nil {
+			return nil, err
+		}
+	} else if len(snapshot.Rects) > 0 {
+		grid = gridFromRects(snapshot.Rects)
+	} else {
+		return nil, errors.New("snapshot has no level")
+	}
+
+	game := makeGame(snapshot.Id, snapshot.Seed, snapshot.Generator)
+	game.lastId = snapshot.LastId
+	game.Level = snapshot.Level
+	game.seedLevel()
+	game.Grid = grid
+	game.Ladder = snapshot.Ladder
+	game.Boss = snapshot.Boss
+	game.Shop = snapshot.Shop
+
+	for _, p := range snapshot.Piles {
+		game.Piles[p.Pos] = &Pile{
+			Id:    p.Id,
+			Items: p.Items,
+		}
+	}
+	for i := range snapshot.Monsters {
+		monster := &snapshot.Monsters[i]
+		monster.Game = game
+		monster.Class = game.content.findMonsterClass(monster.Rune)
+		if monster.Class == nil {
+			monster.Class = &game.content.MonsterClasses[0]
+		}
+		if monster.Loot == nil {
+			monster.Loot = make(map[string]uint)
+		}
+		monster.next = time.Now().Add(monster.interval())
+		game.Monsters[monster] = true
+	}
+	for i := range snapshot.Players {
+		player := &snapshot.Players[i]
+		player.Game = game
+		player.queue = []Message{}
+		game.Players[player] = true
+		game.expireLater(player)
+	}
+
+	return game, nil
+}
+
+func saveGames(path string) error {
+	mux.RLock()
+	list := []*Game{}
+	for _, game := range games {
+		list = append(list, game)
+	}
+	mux.RUnlock()
+
+	snapshots := []GameSnapshot{}
+	for _, game := range list {
+		reply := make(chan GameSnapshot)
+		select {
+		case game.save <- reply:
+			snapshots = append(snapshots, <-reply)
+		case <-game.ctx.Done():
+		}
+	}
+
+	data, err := json.Marshal(snapshots)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func loadGames(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	snapshots := []GameSnapshot{}
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return err
+	}
+
+	mux.Lock()
+	for _, snapshot := range snapshots {
+		if len(snapshot.Players) == 0 {
+			continue
+		}
+		if verbose {
+			log.Println("restore game", snapshot.Id)
+		}
+		game, err := restoreGame(snapshot)
+		if err != nil {
+			log.Println("cannot restore game", snapshot.Id, err)
+			continue
+		}
+		games[game.Id] = game
+		go game.run()
+	}
+	mux.Unlock()
+
+	return os.Remove(path)
+}
@@ -0,0 +1,69 @@
+package main
+
+import "log"
+
+const nobodyToWatch = "There is nobody to watch in this game."
+
+func (game *Game) SpectatorMessage(spectator bool) Message {
+	return Message{
+		"action":    "setSpectator",
+		"spectator": spectator,
+	}
+}
+
+func (game *Game) addSpectator(player *Player) {
+	if len(game.Players) == 0 {
+		player.Enqueue(Message{
+			"action": "notice",
+			"text":   nobodyToWatch,
+		})
+		player.Flush()
+		close(player.send)
+		player.send = nil
+		return
+	}
+
+	if verbose {
+		log.Println("add spectator", game.Id)
+	}
+
+	player.Enqueue(game.SpectatorMessage(true))
+	game.EnqueueState(player)
+
+	game.Spectators[player] = true
+	game.EnqueueRoster()
+}
+
+func (game *Game) removeSpectator(player *Player) {
+	if !game.Spectators[player] {
+		return
+	}
+
+	if verbose {
+		log.Println("remove spectator", game.Id)
+	}
+	delete(game.Spectators, player)
+	if player.send != nil {
+		close(player.send)
+		player.send = nil
+	}
+}
+
+func (game *Game) killPlayer(player *Player) {
+	if player.send == nil {
+		game.removePlayer(player)
+		return
+	}
+
+	game.Spectators[player] = true
+	game.leave(player)
+	player.Enqueue(game.SpectatorMessage(true))
+}
+
+func (game *Game) rejoin(player *Player) {
+	delete(game.Spectators, player)
+	player.reset()
+	player.Token = ""
+	player.Enqueue(game.SpectatorMessage(false))
+	game.joinPlayer(player)
+}
@@ -20,22 +20,41 @@ if (!gameId) {
     location.search = params;
 }
 
+var playerName = params.get('name') || localStorage.getItem('name');
+if (!playerName) {
+    playerName = prompt('What is your name?') || '';
+}
+localStorage.setItem('name', playerName);
+
+var playerColor = params.get('color') || localStorage.getItem('color');
+if (playerColor) {
+    localStorage.setItem('color', playerColor);
+}
+
 var ITEMS = await fetch('/items.json').then(r => r.json());
 
+var CHAT_TIMEOUT = 10000;
+var PROJECTILE_TIMEOUT = 200;
+
+var EFFECTS = {
+    'poison': ['PSN', 2],
+    'regeneration': ['RGN', 1],
+    'haste': ['HST', 6],
+    'slow': ['SLW', 5],
+};
+
 var COLORS = {
     'player': 4,
     'monster': 1,
     'pile': 3,
 };
 
-var inRect = function(pos, rect, withWalls) {
-    if (withWalls) {
-        return pos.x >= rect.x1 - 1 && pos.x <= rect.x2 + 1
-            && pos.y >= rect.y1 - 1 && pos.y <= rect.y2 + 1;
-    } else {
-        return pos.x >= rect.x1 && pos.x <= rect.x2
-            && pos.y >= rect.y1 && pos.y <= rect.y2;
+var decodeTiles = function(encoded) {
+    var tiles = '';
+    for (const [, count, tile] of encoded.matchAll(/(\d*)(\D)/g)) {
+        tiles += tile.repeat(count ? parseInt(count, 10) : 1);
     }
+    return tiles;
 };
 
 var binSearch = function(key) {
@@ -58,7 +77,8 @@ var binSearch = function(key) {
 var game = {
     id: -1,
     level: 0,
-    rects: [],
+    seed: 0,
+    grid: {x: 0, y: 0, width: 0, height: 0, tiles: ''},
     seen: {},
     objects: {},
     stats: {
@@ -70,15 +90,48 @@ var game = {
         lineOfSight: 0,
     },
     inventory: {},
+    effects: {},
+    gold: 0,
+    shop: null,
+    pile: {},
+    projectiles: [],
+    roster: [],
+    chat: [],
     weapon: '',
     armor: '',
+    ranged: '',
+    spectator: false,
+
+    getTile(x, y) {
+        var g = this.grid;
+        if (x < g.x || y < g.y || x >= g.x + g.width || y >= g.y + g.height) {
+            return '#';
+        }
+        return g.tiles[(y - g.y) * g.width + (x - g.x)];
+    },
 
-    getRect(pos, withWalls) {
-        for (const rect of this.rects) {
-            if (inRect(pos, rect, withWalls)) {
-                return rect;
+    isTransparent(x, y) {
+        return !['#', '+', '='].includes(this.getTile(x, y));
+    },
+
+    setTile(pos, tile) {
+        var g = this.grid;
+        var i = (pos.y - g.y) * g.width + (pos.x - g.x);
+        g.tiles = g.tiles.slice(0, i) + tile + g.tiles.slice(i + 1);
+    },
+
+    isWall(x, y) {
+        if (this.getTile(x, y) !== '#') {
+            return false;
+        }
+        for (let dy = -1; dy <= 1; dy++) {
+            for (let dx = -1; dx <= 1; dx++) {
+                if (this.getTile(x + dx, y + dy) !== '#') {
+                    return true;
+                }
             }
         }
+        return false;
     },
 
     inView(a, b, r) {
@@ -89,16 +142,6 @@ var game = {
             return false;
         }
 
-        // perf: shortcut if in same rect
-        for (const rect of this.rects) {
-            if (
-                inRect(a, rect, true) && inRect(b, rect, true)
-                && (inRect(a, rect) || inRect(b, rect))
-            ) {
-                return true;
-            }
-        }
-
         // ray casting
         if (Math.abs(dx) > Math.abs(dy)) {
             const [c, d] = a.x > b.x ? [b, a] : [a, b];
@@ -111,7 +154,7 @@ var game = {
                 const f = (y2 - y1) / (d.x - c.x);
                 for (let x = c.x + 1; x < d.x; x++) {
                     const y = Math.round((x - c.x) * f + y1);
-                    if (!this.getRect({x, y})) {
+                    if (!this.isTransparent(x, y)) {
                         return false;
                     }
                 }
@@ -128,7 +171,7 @@ var game = {
                 const f = (x2 - x1) / (d.y - c.y);
                 for (let y = c.y + 1; y < d.y; y++) {
                     const x = Math.round((y - c.y) * f + x1);
-                    if (!this.getRect({x, y})) {
+                    if (!this.isTransparent(x, y)) {
                         return false;
                     }
                 }
@@ -137,6 +180,35 @@ var game = {
         }
     },
 
+    onShop() {
+        var player = this.objects[this.id];
+        return this.shop && player
+            && player.pos.x === this.shop.pos.x && player.pos.y === this.shop.pos.y;
+    },
+
+    getNeighbor() {
+        var player = this.objects[this.id];
+        return Object.values(this.objects).find(obj => (
+            obj.type === 'player'
+            && obj.id !== this.id
+            && Math.abs(obj.pos.x - player.pos.x) + Math.abs(obj.pos.y - player.pos.y) <= 1
+        ));
+    },
+
+    getName(id) {
+        var player = this.roster.find(p => p.id === id);
+        return player ? player.name : '?';
+    },
+
+    inProjectile(x, y) {
+        return this.projectiles.some(p => (
+            p.time > Date.now() - PROJECTILE_TIMEOUT
+            && x >= Math.min(p.from.x, p.to.x) && x <= Math.max(p.from.x, p.to.x)
+            && y >= Math.min(p.from.y, p.to.y) && y <= Math.max(p.from.y, p.to.y)
+            && !(x === p.from.x && y === p.from.y)
+        ));
+    },
+
     getChar(x, y) {
         if (!this.seen[[x, y]]) {
             return [' ', -1];
@@ -149,10 +221,13 @@ var game = {
         var objs = Object.values(this.objects).filter(obj => x === obj.pos.x && y === obj.pos.y);
         for (const obj of objs) {
             if (obj.type === 'player') {
-                return [obj.rune, COLORS[obj.type]];
+                return [obj.rune, obj.color || COLORS[obj.type]];
             }
         }
         if (inView()) {
+            if (this.inProjectile(x, y)) {
+                return ['*', 3];
+            }
             for (const obj of objs) {
                 if (obj.type === 'monster') {
                     return [obj.rune, COLORS[obj.type]];
@@ -163,13 +238,20 @@ var game = {
             }
         }
         if (x === this.ladder.x && y === this.ladder.y) {
-            return ['>', inView() ? -1 : 0];
+            return ['>', inView() ? (this.locked ? 1 : -1) : 0];
+        }
+        if (this.shop && x === this.shop.pos.x && y === this.shop.pos.y) {
+            return ['$', inView() ? 3 : 0];
         }
-        if (this.getRect({x, y})) {
-            return ['.', inView() ? -1 : 0];
+        var tile = this.getTile(x, y);
+        if (tile === '~') {
+            return ['~', inView() ? 4 : 0];
         }
-        if (this.getRect({x, y}, true)) {
-            return ['#', inView() ? -1 : 0];
+        if (tile === '=') {
+            return ['+', inView() ? 1 : 0];
+        }
+        if (tile !== '#' || this.isWall(x, y)) {
+            return [tile, inView() ? -1 : 0];
         }
         return [' ', -1];
     },
@@ -194,6 +276,8 @@ var screen = {
     menuCursor: 0,
     menuOffset: 0,
     menuSelected: null,
+    menuKind: null,
+    aiming: false,
 
     updateSize() {
         this.rows = binSearch(v => {
@@ -238,7 +322,7 @@ var screen = {
             item[key] ? ((item[key] > 0 ? '+' : '') + item[key]) : '',
             item[key],
         ]);
-        var l1 = Math.max('Weapon'.length, 'Armor'.length, ...rows.map(row => row[0].length));
+        var l1 = Math.max('Weapon'.length, 'Armor'.length, 'Ranged'.length, ...rows.map(row => row[0].length));
         var l2 = Math.max(...rows.map(row => row[1].length));
         var l3 = Math.max(...rows.map(row => row[2].length));
 
@@ -265,21 +349,59 @@ var screen = {
         this.commitSpan(('Weapon'.substr(0, l1) + ':').padEnd(l1 + 2), -1);
         this.commitSpan(game.weapon, 1);
         $pre.append('\n');
+
+        this.commitSpan(('Ranged'.substr(0, l1) + ':').padEnd(l1 + 2), -1);
+        this.commitSpan(game.ranged, 1);
+        $pre.append('\n');
     },
 
     renderHealth() {
-        var cols = this.cols - 4;
+        if (game.spectator) {
+            this.commitSpan('Spectating - press r to join'.padEnd(this.cols - 4).substr(0, this.cols - 4), 3);
+            this.commitSpan(('' + game.level).padStart(4), -1);
+            $pre.append('\n');
+            return;
+        }
+        var effects = Object.keys(game.effects).map(name => EFFECTS[name]);
+        if (this.aiming) {
+            effects.push(['AIM', 3]);
+        }
+        var cols = this.cols - 4 - effects.length * 4;
         var health = Math.round(game.stats.health / game.stats.healthTotal * cols);
-        this.commitSpan('='.repeat(health), 1);
+        this.commitSpan('='.repeat(health), game.effects.poison ? 2 : 1);
         this.commitSpan('='.repeat(cols - health), 0);
+        for (const [label, color] of effects) {
+            this.commitSpan(' ' + label, color);
+        }
         this.commitSpan(('' + game.level).padStart(4), -1);
         $pre.append('\n');
     },
 
+    renderRoster() {
+        for (const player of game.roster) {
+            var health = `${player.health}/${player.healthTotal}`;
+            var name = player.connected ? player.name : `${player.name} (away)`;
+            this.commitSpan('@ ', player.color);
+            this.commitSpan(name.padEnd(this.cols - health.length - 3).substr(0, this.cols - health.length - 3), -1);
+            this.commitSpan(' ' + health, player.health * 4 < player.healthTotal ? 1 : -1);
+            $pre.append('\n');
+        }
+    },
+
     renderMenu() {
-        var rows = this.rows - 5;
-        var items = Object.entries(game.inventory);
+        var rows = this.rows - 9 - game.roster.length;
+        var items = Object.entries(game.inventory)
+            .map(([name, count]) => [name, '' + count, 'inventory']);
         items.sort((a, b) => ITEMS[a[0]].value - ITEMS[b[0]].value);
+        for (const [name, count] of Object.entries(game.pile)) {
+            items.push([name, '+' + count, 'pile']);
+        }
+        if (game.onShop()) {
+            for (const name of game.shop.items) {
+                items.push([name, '$' + ITEMS[name].value, 'shop']);
+            }
+        }
+        var labelLength = Math.max(2, ...items.map(item => item[1].length));
 
         if (this.menuCursor > items.length - 1) {
             this.menuCursor = items.length - 1;
@@ -296,6 +418,7 @@ var screen = {
         }
 
         this.menuSelected = items.length ? items[this.menuCursor][0] : null;
+        this.menuKind = items.length ? items[this.menuCursor][2] : null;
 
         this.table([
             ['Health', 'health'],
@@ -304,13 +427,20 @@ var screen = {
             ['Max Health', 'healthTotal'],
             ['Defense', 'defense'],
             ['Speed', 'speed'],
+            ['Level', 'level'],
+            ['XP', 'xp'],
+            ['Next Level', 'xpNext'],
         ], this.cols);
+        this.commitSpan('Gold: ', -1);
+        this.commitSpan('' + game.gold, 3);
+        $pre.append('\n\n');
+        this.renderRoster();
         $pre.append('\n');
 
         for (let i = 0; i < rows; i++) {
             if (i + this.menuOffset < items.length) {
-                var [name, count] = items[i + this.menuOffset];
-                var line = ` ${count.toString().padStart(2)} ${name}`
+                var [name, label] = items[i + this.menuOffset];
+                var line = ` ${label.padStart(labelLength)} ${name}`
                     .padEnd(this.cols).substr(0, this.cols);
                 var color = i + this.menuOffset === this.menuCursor ? 'inverse' : -1;
                 this.commitSpan(line, color);
@@ -322,12 +452,15 @@ var screen = {
     renderMap() {
         var xOffset = -(this.cols >> 1);
         var yOffset = -(this.rows >> 1);
-        if (game.objects[game.id]) {
-            xOffset += game.objects[game.id].pos.x;
-            yOffset += game.objects[game.id].pos.y;
+        var center = game.objects[game.id] || Object.values(game.objects).find(obj => obj.type === 'player');
+        if (center) {
+            xOffset += center.pos.x;
+            yOffset += center.pos.y;
         }
 
-        for (let y = 1; y < this.rows; y++) {
+        var chat = game.chat.filter(msg => msg.time > Date.now() - CHAT_TIMEOUT).slice(-3);
+
+        for (let y = 1; y < this.rows - chat.length; y++) {
             let span = '';
             let spanColor = -1;
 
@@ -345,6 +478,12 @@ var screen = {
             this.commitSpan(span, spanColor);
             $pre.append('\n');
         }
+
+        for (const msg of chat) {
+            this.commitSpan(msg.name + ': ', msg.color);
+            this.commitSpan(msg.text.substr(0, Math.max(0, this.cols - msg.name.length - 2)), -1);
+            $pre.append('\n');
+        }
     },
 
     render() {
@@ -360,7 +499,26 @@ var screen = {
 };
 
 var socketProtocol = location.protocol.replace('http', 'ws');
-var socket = new WebSocket(`${socketProtocol}//${location.host}/ws/${gameId}`);
+var socketParams = new URLSearchParams();
+socketParams.set('name', playerName);
+if (playerColor) {
+    socketParams.set('color', playerColor);
+}
+socketParams.set('tiles', '1');
+var tokenKey = `token-${gameId}`;
+if (params.get('seed')) {
+    socketParams.set('seed', params.get('seed'));
+}
+if (params.get('generator')) {
+    socketParams.set('generator', params.get('generator'));
+}
+if (params.get('spectate')) {
+    socketParams.set('spectate', '1');
+}
+if (sessionStorage.getItem(tokenKey)) {
+    socketParams.set('token', sessionStorage.getItem(tokenKey));
+}
+var socket = new WebSocket(`${socketProtocol}//${location.host}/ws/${gameId}?${socketParams}`);
 
 var send = function(data) {
     socket.send(JSON.stringify(data));
@@ -375,16 +533,35 @@ socket.onmessage = function(event) {
     for (const msg of messages) {
         if (msg.action === 'setId') {
             game.id = msg.id;
+            sessionStorage.setItem(tokenKey, msg.token);
         } else if (msg.action === 'setLevel') {
             game.level = msg.level;
-            game.rects = msg.rects;
+            game.seed = msg.seed;
+            game.grid = {...msg.grid, tiles: decodeTiles(msg.grid.tiles)};
             game.ladder = msg.ladder;
+            game.locked = msg.locked;
+            game.shop = msg.shop;
             game.seen = {};
             for (const [id, obj] of Object.entries(game.objects)) {
                 if (obj.type !== 'player') {
                     delete game.objects[id];
                 }
             }
+        } else if (msg.action === 'setSpectator') {
+            game.spectator = msg.spectator;
+            game.inventory = {};
+            game.effects = {};
+            game.gold = 0;
+            game.weapon = '';
+            game.armor = '';
+            game.ranged = '';
+            game.pile = {};
+            screen.aiming = false;
+        } else if (msg.action === 'setTile') {
+            game.setTile(msg.pos, msg.tile);
+        } else if (msg.action === 'setLadder') {
+            game.ladder = msg.ladder;
+            game.locked = msg.locked;
         } else if (msg.action === 'create') {
             game.objects[msg.id] = msg;
             if (msg.type === 'player') {
@@ -402,6 +579,31 @@ socket.onmessage = function(event) {
             if (obj.type === 'player') {
                 game.updateSeen(obj.pos, obj.lineOfSight);
             }
+        } else if (msg.action === 'say') {
+            game.chat.push({...msg, time: Date.now()});
+            setTimeout(() => screen.render(), CHAT_TIMEOUT);
+        } else if (msg.action === 'offer') {
+            var items = Object.entries(msg.items).map(([name, count]) => `${count} ${name}`);
+            var accept = confirm(`${game.getName(msg.id)} offers you ${items.join(', ')}. Accept?`);
+            send({action: accept ? 'accept' : 'decline', id: msg.id});
+        } else if (msg.action === 'offerClosed') {
+            game.chat.push({
+                name: game.getName(msg.id),
+                color: -1,
+                text: msg.accepted ? 'accepted your offer' : 'declined your offer',
+                time: Date.now(),
+            });
+            setTimeout(() => screen.render(), CHAT_TIMEOUT);
+        } else if (msg.action === 'notice') {
+            game.chat.push({
+                name: 'server',
+                color: 1,
+                text: msg.text,
+                time: Date.now(),
+            });
+            setTimeout(() => screen.render(), CHAT_TIMEOUT);
+        } else if (msg.action === 'setRoster') {
+            game.roster = msg.players;
         } else if (msg.action === 'setStats') {
             game.stats = msg;
         } else if (msg.action === 'remove') {
@@ -412,8 +614,27 @@ socket.onmessage = function(event) {
             } else {
                 delete game.inventory[msg.item];
             }
+        } else if (msg.action === 'setEffects') {
+            if (msg.id === game.id) {
+                game.effects = msg.effects;
+            } else if (game.objects[msg.id]) {
+                game.objects[msg.id].effects = msg.effects;
+            }
+        } else if (msg.action === 'pileContents') {
+            game.pile = msg.items;
+        } else if (msg.action === 'setItems') {
+            ITEMS = msg.items;
+            game.shop = msg.shop;
+        } else if (msg.action === 'setGold') {
+            game.gold = msg.amount;
         } else if (msg.action === 'setWeapon') {
             game.weapon = msg.item;
+        } else if (msg.action === 'setRanged') {
+            game.ranged = msg.item;
+        } else if (msg.action === 'projectile') {
+            game.projectiles = game.projectiles.filter(p => p.time > Date.now() - PROJECTILE_TIMEOUT);
+            game.projectiles.push({...msg, time: Date.now()});
+            setTimeout(() => screen.render(), PROJECTILE_TIMEOUT);
         } else if (msg.action === 'setArmor') {
             game.armor = msg.item;
         } else {
@@ -423,6 +644,15 @@ socket.onmessage = function(event) {
     screen.render();
 };
 
+var move = function(dir) {
+    if (screen.aiming) {
+        screen.aiming = false;
+        send({action: 'fire', dir: dir});
+    } else {
+        send({action: 'move', dir: dir});
+    }
+};
+
 document.onkeydown = function(event) {
     if (screen.menuOpen) {
         if (event.key === 'ArrowUp' || event.key === 'w') {
@@ -430,32 +660,56 @@ document.onkeydown = function(event) {
         } else if (event.key === 'ArrowDown' || event.key === 's') {
             screen.menuCursor += 1;
         } else if (event.key === 'ArrowRight' || event.key === 'd') {
-            if (screen.menuSelected) {
-                send({action: 'drop', item: screen.menuSelected});
+            if (screen.menuKind === 'inventory') {
+                var action = game.onShop() ? 'sell' : 'drop';
+                send({action: action, item: screen.menuSelected});
+            }
+        } else if (event.key === 'g') {
+            var neighbor = game.getNeighbor();
+            if (screen.menuKind === 'inventory' && neighbor) {
+                send({action: 'offer', target: neighbor.id, items: {[screen.menuSelected]: 1}});
             }
         } else if (event.key === 'q') {
             screen.toggleMenu();
         } else if (event.key === 'Enter' || event.key === 'e') {
-            if (screen.menuSelected) {
+            if (screen.menuKind === 'shop') {
+                send({action: 'buy', item: screen.menuSelected});
+            } else if (screen.menuKind === 'pile') {
+                send({action: 'pickup', item: screen.menuSelected});
+            } else if (screen.menuKind === 'inventory') {
                 send({action: 'use', item: screen.menuSelected});
             }
         } else {
             return;
         }
         screen.render();
+    } else if (game.spectator) {
+        if (event.key === 'r') {
+            send({action: 'rejoin'});
+        } else {
+            return;
+        }
     } else {
         if (event.key === 'ArrowUp' || event.key === 'w') {
-            send({action: 'move', dir: 'up'});
+            move('up');
         } else if (event.key === 'ArrowRight' || event.key === 'd') {
-            send({action: 'move', dir: 'right'});
+            move('right');
         } else if (event.key === 'ArrowDown' || event.key === 's') {
-            send({action: 'move', dir: 'down'});
+            move('down');
         } else if (event.key === 'ArrowLeft' || event.key === 'a') {
-            send({action: 'move', dir: 'left'});
+            move('left');
+        } else if (event.key === 'f') {
+            screen.aiming = !screen.aiming;
+            screen.render();
         } else if (event.key === 'q') {
             screen.toggleMenu();
         } else if (event.key === 'Enter' || event.key === 'e') {
             send({action: 'pickup'});
+        } else if (event.key === 't') {
+            var text = prompt('Say');
+            if (text) {
+                send({action: 'say', text: text});
+            }
         } else {
             return;
         }
@@ -478,7 +732,7 @@ onDPad(document.querySelector('#dpad'), dir => {
 
 onDPad(document.querySelector('#buttons'), dir => {
     var keys = {
-        'up': null,
+        'up': 'f',
         'right': 'e',
         'down': null,
         'left': 'q',
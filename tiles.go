@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type Tile byte
+
+const (
+	WALL Tile = iota
+	FLOOR
+	DOOR
+	WATER
+	OPEN
+	LOCKED
+)
+
+var tileRunes = []byte{'#', '.', '+', '~', '\'', '='}
+
+func (tile Tile) Walkable() bool {
+	return tile == FLOOR || tile == OPEN
+}
+
+func (tile Tile) Transparent() bool {
+	return tile != WALL && tile != DOOR && tile != LOCKED
+}
+
+func (tile Tile) String() string {
+	return string(tileRunes[tile])
+}
+
+// A Grid is the authoritative model of a level. Everything outside of it is
+// WALL.
+type Grid struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+	Tiles  []Tile
+}
+
+// GridData is the compact encoding of a Grid that is used in messages and
+// snapshots. Tiles are run-length encoded, e.g. "3#." for "###.".
+type GridData struct {
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Tiles  string `json:"tiles"`
+}
+
+func makeGrid(bounds Rect) *Grid {
+	width := bounds.X2 - bounds.X1 + 1
+	height := bounds.Y2 - bounds.Y1 + 1
+	return &Grid{
+		X:      bounds.X1,
+		Y:      bounds.Y1,
+		Width:  width,
+		Height: height,
+		Tiles:  make([]Tile, width*height),
+	}
+}
+
+func gridFromRects(rects []Rect) *Grid {
+	bounds := rects[0]
+	for _, rect := range rects {
+		bounds.X1 = min(bounds.X1, rect.X1)
+		bounds.Y1 = min(bounds.Y1, rect.Y1)
+		bounds.X2 = max(bounds.X2, rect.X2)
+		bounds.Y2 = max(bounds.Y2, rect.Y2)
+	}
+
+	grid := makeGrid(bounds)
+	for _, rect := range rects {
+		for y := rect.Y1; y <= rect.Y2; y++ {
+			for x := rect.X1; x <= rect.X2; x++ {
+				grid.Set(Point{x, y}, FLOOR)
+			}
+		}
+	}
+	return grid
+}
+
+func (grid *Grid) index(p Point) (int, bool) {
+	x := p.X - grid.X
+	y := p.Y - grid.Y
+	if x < 0 || y < 0 || x >= grid.Width || y >= grid.Height {
+		return 0, false
+	}
+	return y*grid.Width + x, true
+}
+
+func (grid *Grid) Get(p Point) Tile {
+	if i, ok := grid.index(p); ok {
+		return grid.Tiles[i]
+	}
+	return WALL
+}
+
+func (grid *Grid) Set(p Point, tile Tile) {
+	if i, ok := grid.index(p); ok {
+		grid.Tiles[i] = tile
+	}
+}
+
+// Rects approximates the grid as horizontal runs of floor and doors for
+// clients that do not understand tiles.
+func (grid *Grid) Rects() []Rect {
+	floor := func(tile Tile) bool {
+		return tile != WALL && tile != WATER
+	}
+
+	rects := []Rect{}
+	for y := 0; y < grid.Height; y++ {
+		for x := 0; x < grid.Width; x++ {
+			if !floor(grid.Tiles[y*grid.Width+x]) {
+				continue
+			}
+			start := x
+			for x+1 < grid.Width && floor(grid.Tiles[y*grid.Width+x+1]) {
+				x++
+			}
+			rects = append(rects, Rect{grid.X + start, grid.Y + y, grid.X + x, grid.Y + y})
+		}
+	}
+	return rects
+}
+
+func (grid *Grid) Data() GridData {
+	var b strings.Builder
+	for i := 0; i < len(grid.Tiles); {
+		j := i + 1
+		for j < len(grid.Tiles) && grid.Tiles[j] == grid.Tiles[i] {
+			j++
+		}
+		if j-i > 1 {
+			b.WriteString(strconv.Itoa(j - i))
+		}
+		b.WriteByte(tileRunes[grid.Tiles[i]])
+		i = j
+	}
+
+	return GridData{
+		X:      grid.X,
+		Y:      grid.Y,
+		Width:  grid.Width,
+		Height: grid.Height,
+		Tiles:  b.String(),
+	}
+}
+
+func (data GridData) Grid() (*Grid, error) {
+	if data.Width < 0 || data.Height < 0 {
+		return nil, errors.New("invalid grid size")
+	}
+
+	grid := makeGrid(Rect{data.X, data.Y, data.X + data.Width - 1, data.Y + data.Height - 1})
+	grid.Tiles = grid.Tiles[:0]
+	count := 0
+	for i := 0; i < len(data.Tiles); i++ {
+		c := data.Tiles[i]
+		if c >= '0' && c <= '9' {
+			count = count*10 + int(c-'0')
+			continue
+		}
+
+		tile := strings.IndexByte(string(tileRunes), c)
+		if tile == -1 {
+			return nil, errors.New("invalid tile " + strconv.Quote(string(c)))
+		}
+		if count == 0 {
+			count = 1
+		}
+		if len(grid.Tiles)+count > data.Width*data.Height {
+			return nil, errors.New("too many tiles")
+		}
+		for ; count > 0; count-- {
+			grid.Tiles = append(grid.Tiles, Tile(tile))
+		}
+	}
+
+	if len(grid.Tiles) != data.Width*data.Height {
+		return nil, errors.New("too few tiles")
+	}
+	return grid, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+func parseAmount(value interface{}) (uint, bool) {
+	amount, ok := value.(float64)
+	if !ok || amount < 1 || amount != float64(uint(amount)) {
+		return 0, false
+	}
+	return uint(amount), true
+}
+
+func parseItems(value interface{}) (map[string]uint, bool) {
+	raw, ok := value.(map[string]interface{})
+	if !ok || len(raw) == 0 {
+		return nil, false
+	}
+
+	items := make(map[string]uint)
+	for name, v := range raw {
+		amount, ok := parseAmount(v)
+		if !ok {
+			return nil, false
+		}
+		items[name] = amount
+	}
+	return items, true
+}
+
+func (player *Player) canTrade(other *Player, items map[string]uint) bool {
+	if other == nil || other == player || player.Pos.Dist(other.Pos) > 1 {
+		return false
+	}
+	for name, amount := range items {
+		if player.Inventory[name] < amount {
+			return false
+		}
+	}
+	return true
+}
+
+func (player *Player) Offer(id int, items map[string]uint) {
+	target := player.Game.getPlayer(id)
+	if !player.canTrade(target, items) {
+		return
+	}
+
+	if target.offers == nil {
+		target.offers = make(map[int]map[string]uint)
+	}
+	target.offers[player.Id] = items
+
+	target.Enqueue(Message{
+		"action": "offer",
+		"id":     player.Id,
+		"items":  items,
+	})
+}
+
+func (player *Player) CloseOffer(id int, accept bool) {
+	items, ok := player.offers[id]
+	if !ok {
+		return
+	}
+	delete(player.offers, id)
+
+	from := player.Game.getPlayer(id)
+	if from == nil {
+		return
+	}
+
+	accepted := accept && from.canTrade(player, items)
+	if accepted {
+		for name, amount := range items {
+			for i := uint(0); i < amount; i++ {
+				from.RemoveItem(name)
+			}
+			player.AddItem(name, amount)
+		}
+	}
+
+	from.Enqueue(Message{
+		"action":   "offerClosed",
+		"id":       player.Id,
+		"accepted": accepted,
+	})
+}